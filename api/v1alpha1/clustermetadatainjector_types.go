@@ -0,0 +1,66 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster,shortName=cmi
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Interval",type="string",JSONPath=".status.interval"
+// +kubebuilder:printcolumn:name="Matched",type="integer",JSONPath=".status.matched"
+// +kubebuilder:printcolumn:name="Updated",type="integer",JSONPath=".status.updated"
+// +kubebuilder:printcolumn:name="Last Success",type="string",JSONPath=".status.lastSuccessfulTime"
+// +kubebuilder:printcolumn:name="Next Run",type="string",JSONPath=".status.nextScheduledTime"
+
+// ClusterMetadataInjector is the Schema for the clustermetadatainjectors API.
+// Unlike MetadataInjector, it may select resources in any namespace as well as
+// cluster-scoped resources.
+type ClusterMetadataInjector struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MetadataInjectorSpec   `json:"spec,omitempty"`
+	Status MetadataInjectorStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterMetadataInjectorList contains a list of ClusterMetadataInjector
+type ClusterMetadataInjectorList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterMetadataInjector `json:"items"`
+}
+
+// GetSpec returns the spec of the injector
+func (in *ClusterMetadataInjector) GetSpec() *MetadataInjectorSpec {
+	return &in.Spec
+}
+
+// GetStatus returns the status of the injector
+func (in *ClusterMetadataInjector) GetStatus() *MetadataInjectorStatus {
+	return &in.Status
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterMetadataInjector{}, &ClusterMetadataInjectorList{})
+}
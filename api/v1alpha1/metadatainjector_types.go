@@ -17,7 +17,47 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"time"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// AnnotationReconcileInterval sets the interval between scheduled runs of an injector
+	// Deprecated: use spec.interval instead
+	AnnotationReconcileInterval = "metadata-injector.ruso.dev/reconcile-interval"
+
+	// AnnotationDisableAutoReconcile stops the scheduled runs of an injector when set to "true"
+	// Deprecated: use spec.suspend instead
+	AnnotationDisableAutoReconcile = "metadata-injector.ruso.dev/disable-auto-reconcile"
+
+	// MinimumInterval is the shortest interval allowed between scheduled runs
+	MinimumInterval = 10 * time.Second
+)
+
+// Injector is implemented by MetadataInjector and ClusterMetadataInjector,
+// which share the same spec and status
+// +kubebuilder:object:generate=false
+type Injector interface {
+	client.Object
+	GetSpec() *MetadataInjectorSpec
+	GetStatus() *MetadataInjectorStatus
+}
+
+// ConflictPolicy defines how an injector handles keys a resource already sets to another value
+// +kubebuilder:validation:Enum=Overwrite;IfNotPresent;Fail
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyOverwrite replaces the existing values
+	ConflictPolicyOverwrite ConflictPolicy = "Overwrite"
+
+	// ConflictPolicyIfNotPresent only sets the keys the resource does not have yet
+	ConflictPolicyIfNotPresent ConflictPolicy = "IfNotPresent"
+
+	// ConflictPolicyFail leaves the resource untouched and reports the conflict
+	ConflictPolicyFail ConflictPolicy = "Fail"
 )
 
 // MetadataInjectorSpec defines the desired state of MetadataInjector
@@ -30,6 +70,59 @@ type MetadataInjectorSpec struct {
 	// Inject defines the metadata to inject into the selected resources
 	// +kubebuilder:validation:Required
 	Inject MetadataInjection `json:"inject"`
+
+	// Prune removes the keys this injector previously injected but no longer declares in Inject
+	// +optional
+	Prune bool `json:"prune,omitempty"`
+
+	// ConflictPolicy defines how keys a resource already sets to another value are handled
+	// Overwrite replaces them, IfNotPresent only sets the missing keys, and Fail leaves the resource untouched
+	// and reports the conflict in the Conflict condition. Keys previously set by this injector are never conflicts
+	// +kubebuilder:default=Overwrite
+	// +optional
+	ConflictPolicy ConflictPolicy `json:"conflictPolicy,omitempty"`
+
+	// Interval is the time between scheduled runs, such as 30m or 24h
+	// If empty, the reconcile interval annotation or else the operator's default interval is used
+	// +kubebuilder:validation:XValidation:rule="duration(self) >= duration('10s')",message="interval must be at least 10s"
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Suspend stops the scheduled runs while true
+	// Watch mode and the admission webhook keep applying the metadata
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// Schedule is a cron expression, such as "0 2 * * *", defining when the scheduled runs happen
+	// It takes precedence over Interval
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+
+	// TimeZone is the IANA time zone the schedule is evaluated in, such as "Europe/Madrid"
+	// If empty, the time zone of the operator is used
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Watch applies the metadata as soon as matching resources are created or modified,
+	// in addition to the scheduled runs
+	// +optional
+	Watch bool `json:"watch,omitempty"`
+
+	// ServiceAccountName is the service account impersonated to list and patch the selected resources,
+	// so the injector can only touch what that service account is allowed to
+	// If empty, the operator's own service account is used
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// ServiceAccountNamespace is the namespace of the service account named in ServiceAccountName
+	// It is required by a ClusterMetadataInjector, while a MetadataInjector always uses its own namespace
+	// +optional
+	ServiceAccountNamespace string `json:"serviceAccountNamespace,omitempty"`
+
+	// DryRun computes the changes the injector would make to the selected resources without writing them
+	// The planned changes are reported in status.plan, and watch mode and the admission webhook are disabled
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
 }
 
 // ResourceSelector defines the resource selection criteria
@@ -48,14 +141,30 @@ type ResourceSelector struct {
 	Version string `json:"version,omitempty"`
 
 	// Namespaces is the list of namespaces to target
-	// If empty, targets all namespaces
+	// If both Namespaces and NamespaceSelector are empty, targets all namespaces
+	// A MetadataInjector only ever targets its own namespace
 	// +optional
 	Namespaces []string `json:"namespaces,omitempty"`
 
+	// NamespaceSelector selects the namespaces to target by their labels
+	// Matching namespaces are targeted in addition to the ones listed in Namespaces
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// ExcludeNamespaces is the list of namespaces to skip
+	// Takes precedence over Namespaces and NamespaceSelector
+	// +optional
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+
 	// Names is the list of resource names to target
 	// If empty, targets all resources of the specified kind
 	// +optional
 	Names []string `json:"names,omitempty"`
+
+	// LabelSelector restricts the selection to resources whose labels match
+	// If empty, resources are not filtered by labels
+	// +optional
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
 }
 
 // MetadataInjection defines the metadata to inject
@@ -87,6 +196,26 @@ type MetadataInjectorStatus struct {
 	// +optional
 	Interval string `json:"interval,omitempty"`
 
+	// Updated is the number of resources changed during the last run
+	// +optional
+	Updated int32 `json:"updated,omitempty"`
+
+	// InSync is the number of resources that already had the desired metadata during the last run
+	// +optional
+	InSync int32 `json:"inSync,omitempty"`
+
+	// Matched is the number of resources selected during the last run
+	// +optional
+	Matched int32 `json:"matched,omitempty"`
+
+	// Selectors reports the outcome of the last run for each entry in spec.selectors
+	// +optional
+	Selectors []SelectorStatus `json:"selectors,omitempty"`
+
+	// Plan lists the changes the last dry run would have made
+	// +optional
+	Plan *DryRunPlan `json:"plan,omitempty"`
+
 	// Conditions represent the latest available observations of an object's state
 	// +optional
 	// +patchMergeKey=type
@@ -96,15 +225,109 @@ type MetadataInjectorStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// SelectorStatus reports the outcome of the last run for a single selector
+type SelectorStatus struct {
+	// Index is the position of the selector in spec.selectors
+	Index int32 `json:"index"`
+
+	// Kind is the resource kind targeted by the selector
+	Kind string `json:"kind"`
+
+	// Resource is the group/version/resource the selector resolved to
+	// +optional
+	Resource string `json:"resource,omitempty"`
+
+	// Matched is the number of resources selected
+	Matched int32 `json:"matched"`
+
+	// Updated is the number of resources changed
+	Updated int32 `json:"updated"`
+
+	// InSync is the number of resources that already had the desired metadata
+	InSync int32 `json:"inSync"`
+
+	// Failed is the number of resources that could not be updated
+	Failed int32 `json:"failed"`
+
+	// Skipped is the number of resources where some desired keys were not applied
+	// because the IfNotPresent conflict policy preserved their current value.
+	// These resources are also counted as updated or in sync
+	// +optional
+	Skipped int32 `json:"skipped,omitempty"`
+
+	// LastSkipped is the last resource where desired keys were not applied, with those keys
+	// +optional
+	LastSkipped string `json:"lastSkipped,omitempty"`
+
+	// Conflicts is the number of resources left untouched because of conflicting keys under the Fail conflict policy
+	// +optional
+	Conflicts int32 `json:"conflicts,omitempty"`
+
+	// LastConflict is the last resource left untouched because of conflicting keys, with those keys
+	// +optional
+	LastConflict string `json:"lastConflict,omitempty"`
+
+	// LastError is the last error encountered while processing the selector
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+}
+
+// DryRunPlan reports the changes a dry run would make to the selected resources
+type DryRunPlan struct {
+	// Total is the number of resources that would be changed
+	Total int32 `json:"total"`
+
+	// Changes lists the planned change of each resource
+	// It is left empty when the plan is too large for the status and stored in ConfigMapName instead
+	// +optional
+	Changes []PlannedChange `json:"changes,omitempty"`
+
+	// ConfigMapName is the ConfigMap holding the full plan when it is too large for the status
+	// It lives in the injector's namespace, or in the operator's namespace for a ClusterMetadataInjector
+	// +optional
+	ConfigMapName string `json:"configMapName,omitempty"`
+}
+
+// PlannedChange reports the metadata changes a dry run would make to a single resource
+// Keys are prefixed with "label:" or "annotation:"
+type PlannedChange struct {
+	// Resource is the group/version/resource of the changed resource
+	Resource string `json:"resource"`
+
+	// Namespace of the changed resource, empty for cluster-scoped resources
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name of the changed resource
+	Name string `json:"name"`
+
+	// Added lists the keys that would be added
+	// +optional
+	Added []string `json:"added,omitempty"`
+
+	// Overwritten lists the keys whose value would be replaced
+	// +optional
+	Overwritten []string `json:"overwritten,omitempty"`
+
+	// Removed lists the keys that would be removed
+	// +optional
+	Removed []string `json:"removed,omitempty"`
+}
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
 // +kubebuilder:printcolumn:name="Interval",type="string",JSONPath=".status.interval"
+// +kubebuilder:printcolumn:name="Matched",type="integer",JSONPath=".status.matched"
+// +kubebuilder:printcolumn:name="Updated",type="integer",JSONPath=".status.updated"
 // +kubebuilder:printcolumn:name="Last Success",type="string",JSONPath=".status.lastSuccessfulTime"
 // +kubebuilder:printcolumn:name="Next Run",type="string",JSONPath=".status.nextScheduledTime"
 // +kubebuilder:resource:shortName=mi
 
-// MetadataInjector is the Schema for the metadatainjectors API
+// MetadataInjector is the Schema for the metadatainjectors API.
+// It only selects resources in its own namespace; use a ClusterMetadataInjector
+// to target other namespaces or cluster-scoped resources.
 type MetadataInjector struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -122,6 +345,16 @@ type MetadataInjectorList struct {
 	Items           []MetadataInjector `json:"items"`
 }
 
+// GetSpec returns the spec of the injector
+func (in *MetadataInjector) GetSpec() *MetadataInjectorSpec {
+	return &in.Spec
+}
+
+// GetStatus returns the status of the injector
+func (in *MetadataInjector) GetStatus() *MetadataInjectorStatus {
+	return &in.Status
+}
+
 func init() {
 	SchemeBuilder.Register(&MetadataInjector{}, &MetadataInjectorList{})
 }
@@ -19,7 +19,10 @@ package main
 import (
 	"crypto/tls"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -34,9 +37,11 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/yaml"
 
 	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
 	"github.com/ruslanguns/metadata-injector-operator/internal/controller"
+	webhookcorev1alpha1 "github.com/ruslanguns/metadata-injector-operator/internal/webhook/v1alpha1"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -58,6 +63,15 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var enableWebhooks bool
+	var crossNamespaceAllowlist string
+	var configFile string
+	var workers int
+	var batchInterval time.Duration
+	var defaultReconcileInterval time.Duration
+	var maxConcurrentReconciles int
+	var targetEvents bool
+	var namespace string
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -69,6 +83,26 @@ func main() {
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"If set, the admission webhooks are served. This requires a serving certificate for the webhook server.")
+	flag.StringVar(&crossNamespaceAllowlist, "cross-namespace-allowlist", "",
+		"Comma-separated list of namespaces whose MetadataInjectors may target resources in other namespaces.")
+	defaults := controller.DefaultOptions()
+	flag.StringVar(&configFile, "config", "",
+		"Path to a YAML file holding the controller options. Flags that are set explicitly take precedence over it.")
+	flag.IntVar(&workers, "workers", defaults.Workers,
+		"The number of injectors the scheduler processes concurrently.")
+	flag.DurationVar(&batchInterval, "batch-interval", defaults.BatchInterval.Duration,
+		"How often the scheduler looks for injectors that are due.")
+	flag.DurationVar(&defaultReconcileInterval, "default-reconcile-interval", defaults.DefaultReconcileInterval.Duration,
+		"The reconcile interval of the injectors that set neither an interval nor a schedule.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", defaults.MaxConcurrentReconciles,
+		"The number of injectors each controller reconciles concurrently.")
+	flag.BoolVar(&targetEvents, "target-events", defaults.TargetEvents,
+		"If set, an event is recorded on every resource whose metadata is changed by an injector.")
+	flag.StringVar(&namespace, "namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace the operator runs in, where the dry-run plans of ClusterMetadataInjectors are stored. "+
+			"Defaults to the POD_NAMESPACE environment variable.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -77,6 +111,33 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	controllerOpts, err := loadOptions(configFile)
+	if err != nil {
+		setupLog.Error(err, "unable to load the controller options", "config", configFile)
+		os.Exit(1)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "workers":
+			controllerOpts.Workers = workers
+		case "batch-interval":
+			controllerOpts.BatchInterval.Duration = batchInterval
+		case "default-reconcile-interval":
+			controllerOpts.DefaultReconcileInterval.Duration = defaultReconcileInterval
+		case "max-concurrent-reconciles":
+			controllerOpts.MaxConcurrentReconciles = maxConcurrentReconciles
+		case "target-events":
+			controllerOpts.TargetEvents = targetEvents
+		case "namespace":
+			controllerOpts.Namespace = namespace
+		case "cross-namespace-allowlist":
+			controllerOpts.CrossNamespaceAllowlist = splitList(crossNamespaceAllowlist)
+		}
+	})
+	if controllerOpts.Namespace == "" {
+		controllerOpts.Namespace = namespace
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -142,13 +203,35 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controller.MetadataInjectorReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	reconciler := &controller.MetadataInjectorReconciler{
+		Client:  mgr.GetClient(),
+		Scheme:  mgr.GetScheme(),
+		Options: controllerOpts,
+	}
+	if err = reconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "MetadataInjector")
 		os.Exit(1)
 	}
+	if err = (&controller.ClusterMetadataInjectorReconciler{
+		MetadataInjectorReconciler: reconciler,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterMetadataInjector")
+		os.Exit(1)
+	}
+	if enableWebhooks {
+		if err = reconciler.SetupInjectionWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "MetadataInjection")
+			os.Exit(1)
+		}
+		if err = webhookcorev1alpha1.SetupMetadataInjectorWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "MetadataInjector")
+			os.Exit(1)
+		}
+		if err = webhookcorev1alpha1.SetupClusterMetadataInjectorWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ClusterMetadataInjector")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -166,3 +249,32 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// loadOptions reads the controller options from the config file, if any,
+// leaving the options it does not set at their default value
+func loadOptions(path string) (controller.Options, error) {
+	options := controller.DefaultOptions()
+	if path == "" {
+		return options, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return options, err
+	}
+	if err := yaml.UnmarshalStrict(data, &options); err != nil {
+		return options, fmt.Errorf("invalid config file: %w", err)
+	}
+	return options, nil
+}
+
+// splitList parses a comma-separated flag value, ignoring empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
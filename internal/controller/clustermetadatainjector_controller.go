@@ -0,0 +1,71 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	crcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+// ClusterMetadataInjectorReconciler reconciles a ClusterMetadataInjector object,
+// sharing the scheduler and watcher of the MetadataInjector reconciler
+// +kubebuilder:rbac:groups=core.k8s.ruso.dev,resources=clustermetadatainjectors,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core.k8s.ruso.dev,resources=clustermetadatainjectors/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core.k8s.ruso.dev,resources=clustermetadatainjectors/finalizers,verbs=update
+type ClusterMetadataInjectorReconciler struct {
+	*MetadataInjectorReconciler
+}
+
+func (r *ClusterMetadataInjectorReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var injector corev1alpha1.ClusterMetadataInjector
+	if err := r.Get(ctx, req.NamespacedName, &injector); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("ClusterMetadataInjector resource not found. Ignoring since object must be deleted")
+			r.scheduler.Unschedule(req.NamespacedName)
+			r.watcher.Unregister(ctx, req.NamespacedName.String())
+			forgetInjectorMetrics(req.NamespacedName.String())
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get ClusterMetadataInjector")
+		return ctrl.Result{}, err
+	}
+
+	return r.reconcileInjector(ctx, &injector)
+}
+
+// SetupWithManager sets up the controller with the Manager. It must be called
+// after the SetupWithManager of the embedded MetadataInjectorReconciler.
+func (r *ClusterMetadataInjectorReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.MetadataInjectorReconciler == nil || r.scheduler == nil {
+		return fmt.Errorf("the MetadataInjector controller must be set up before the ClusterMetadataInjector controller")
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1alpha1.ClusterMetadataInjector{}, builder.WithPredicates(injectorPredicates)).
+		WithOptions(crcontroller.Options{MaxConcurrentReconciles: r.Options.MaxConcurrentReconciles}).
+		Complete(r)
+}
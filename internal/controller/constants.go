@@ -1,11 +1,65 @@
 package controller
 
-import "time"
+import (
+	"time"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
 
 const (
-	annotationDisableAutoReconcile = "metadata-injector.ruso.dev/disable-auto-reconcile"
-	annotationReconcileInterval    = "metadata-injector.ruso.dev/reconcile-interval"
+	fieldManager                   = "metadata-injector"
+	finalizerName                  = "metadata-injector.ruso.dev/finalizer"
+	annotationDisableAutoReconcile = corev1alpha1.AnnotationDisableAutoReconcile
+	annotationReconcileInterval    = corev1alpha1.AnnotationReconcileInterval
+	annotationManagedKeys          = "metadata-injector.ruso.dev/managed-keys"
 	defaultReconcileInterval       = 5 * time.Minute
-	defaultBatchInterval           = 1 * time.Minute
+	defaultBatchInterval           = 10 * time.Second
+	inFlightRequeueDelay           = 5 * time.Second
 	defaultWorkers                 = 5
+	defaultMaxConcurrentReconciles = 1
+	defaultNamespace               = "default"
+	maxPlannedChangesInStatus      = 50
+	planConfigMapKey               = "plan.json"
+	maxWatchRetries                = 5
+)
+
+const (
+	conditionTypeReady        = "Ready"
+	conditionTypeDegraded     = "Degraded"
+	conditionTypeProgressing  = "Progressing"
+	conditionTypeInvalidSpec  = "InvalidSpec"
+	conditionTypeUnauthorized = "Unauthorized"
+	conditionTypeDeprecated   = "Deprecated"
+	conditionTypeConflict     = "Conflict"
+	conditionTypeKeysSkipped  = "KeysSkipped"
+
+	reasonSynced                = "Synced"
+	reasonUpdateFailed          = "UpdateFailed"
+	reasonInvalidSpec           = "InvalidSpec"
+	reasonUnauthorized          = "Unauthorized"
+	reasonRunning               = "Running"
+	reasonCompleted             = "Completed"
+	reasonSpecValid             = "SpecValid"
+	reasonUnknownKind           = "UnknownKind"
+	reasonInvalidInterval       = "InvalidInterval"
+	reasonInvalidSchedule       = "InvalidSchedule"
+	reasonInvalidSuspend        = "InvalidSuspend"
+	reasonUpToDate              = "UpToDate"
+	reasonDeprecatedAnnotations = "DeprecatedAnnotations"
+	reasonAuthorized            = "Authorized"
+	reasonOutOfScope            = "OutOfScope"
+	reasonNoConflicts           = "NoConflicts"
+	reasonConflictingKeys       = "ConflictingKeys"
+	reasonAllKeysApplied        = "AllKeysApplied"
+	reasonPreservedValues       = "PreservedExistingValues"
+)
+
+const (
+	eventReasonRunStarted       = "RunStarted"
+	eventReasonRunCompleted     = "RunCompleted"
+	eventReasonPartialFailure   = "PartialFailure"
+	eventReasonInvalidSelector  = "InvalidSelector"
+	eventReasonMetadataInjected = "MetadataInjected"
+	eventReasonConflict         = "Conflict"
+	eventReasonCleanupSkipped   = "CleanupSkipped"
 )
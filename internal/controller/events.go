@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+// recordRunEvents reports the outcome of a run on the injector
+func (bs *BatchScheduler) recordRunEvents(injector corev1alpha1.Injector, result JobResult) {
+	for _, unresolved := range result.UnresolvedSelectors {
+		bs.recorder.Event(injector, corev1.EventTypeWarning, eventReasonInvalidSelector, unresolved)
+	}
+
+	if result.conflicted() {
+		bs.recorder.Eventf(injector, corev1.EventTypeWarning, eventReasonConflict,
+			"%d resources were left untouched because of conflicting keys: %s", result.totals().Conflicts, result.lastConflict())
+	}
+
+	if result.degraded() {
+		bs.recorder.Eventf(injector, corev1.EventTypeWarning, eventReasonPartialFailure,
+			"%d resources failed, %s: %s", result.totals().Failed, result.summary(), result.lastError())
+		return
+	}
+	bs.recorder.Event(injector, corev1.EventTypeNormal, eventReasonRunCompleted, result.summary())
+}
+
+// recordTargetEvent reports on the target which of its labels and annotations
+// the injector changed, so its owners can find out from kubectl describe
+func (bs *BatchScheduler) recordTargetEvent(injector corev1alpha1.Injector, gvr schema.GroupVersionResource, original, modified *unstructured.Unstructured) {
+	if !bs.targetEvents {
+		return
+	}
+
+	gvk, err := bs.restMapper.KindFor(gvr)
+	if err != nil {
+		return
+	}
+	// The reference is built by hand since items coming from the metadata
+	// informers do not carry the kind of the resource
+	target := &corev1.ObjectReference{
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+		Namespace:  modified.GetNamespace(),
+		Name:       modified.GetName(),
+		UID:        modified.GetUID(),
+	}
+
+	bs.recorder.Eventf(target, corev1.EventTypeNormal, eventReasonMetadataInjected,
+		"%s %s changed %s", injectorKind(injector), injectorKey(injector), strings.Join(changedKeys(original, modified), ", "))
+}
+
+// changedKeys lists the labels and annotations added, modified or removed
+// between original and modified, leaving out the ownership record
+func changedKeys(original, modified *unstructured.Unstructured) []string {
+	added, overwritten, removed := diffMetadata(original, modified)
+	keys := slices.Concat(added, overwritten, removed)
+	slices.Sort(keys)
+	return keys
+}
+
+func injectorKind(injector corev1alpha1.Injector) string {
+	if _, ok := injector.(*corev1alpha1.ClusterMetadataInjector); ok {
+		return "ClusterMetadataInjector"
+	}
+	return "MetadataInjector"
+}
@@ -2,54 +2,252 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"maps"
+	"slices"
 	"strconv"
 	"time"
 
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
 )
 
-func shouldProcess(injector *corev1alpha1.MetadataInjector) bool {
-	if disabled, _ := strconv.ParseBool(injector.Annotations[annotationDisableAutoReconcile]); disabled {
+func shouldProcess(injector corev1alpha1.Injector) bool {
+	if !injector.GetDeletionTimestamp().IsZero() {
 		return false
 	}
-	return true
+	// An unparsable annotation is reported by processJob
+	suspended, _ := isSuspended(injector)
+	return !suspended
 }
 
-func calculateNextRun(injector *corev1alpha1.MetadataInjector) time.Time {
-	interval := defaultReconcileInterval
-	if customInterval, ok := injector.Annotations[annotationReconcileInterval]; ok {
-		if parsed, err := time.ParseDuration(customInterval); err == nil {
-			interval = parsed
-		}
+// isSuspended reports whether the scheduled runs are stopped, from
+// spec.suspend or else the deprecated disable annotation
+func isSuspended(injector corev1alpha1.Injector) (bool, error) {
+	if injector.GetSpec().Suspend {
+		return true, nil
+	}
+
+	raw, ok := injector.GetAnnotations()[annotationDisableAutoReconcile]
+	if !ok {
+		return false, nil
+	}
+	disabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s annotation: %w", annotationDisableAutoReconcile, err)
+	}
+	return disabled, nil
+}
+
+// reconcileInterval returns the time between scheduled runs, from
+// spec.interval or else the deprecated interval annotation. The default
+// interval is returned along with the error of an unparsable annotation.
+func (bs *BatchScheduler) reconcileInterval(injector corev1alpha1.Injector) (time.Duration, error) {
+	if interval := injector.GetSpec().Interval; interval != nil {
+		return interval.Duration, nil
+	}
+
+	raw, ok := injector.GetAnnotations()[annotationReconcileInterval]
+	if !ok {
+		return bs.defaultReconcileInterval, nil
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil {
+		return bs.defaultReconcileInterval, fmt.Errorf("invalid %s annotation: %w", annotationReconcileInterval, err)
 	}
+	if interval < corev1alpha1.MinimumInterval {
+		return bs.defaultReconcileInterval, fmt.Errorf("invalid %s annotation: must be at least %s", annotationReconcileInterval, corev1alpha1.MinimumInterval)
+	}
+	return interval, nil
+}
+
+// deprecatedAnnotations returns the scheduling annotations set on the injector
+// along with the spec field replacing each of them
+func deprecatedAnnotations(injector corev1alpha1.Injector) []string {
+	var deprecated []string
+	annotations := injector.GetAnnotations()
+	if _, ok := annotations[annotationReconcileInterval]; ok {
+		deprecated = append(deprecated, fmt.Sprintf("the %s annotation is deprecated, use spec.interval instead", annotationReconcileInterval))
+	}
+	if _, ok := annotations[annotationDisableAutoReconcile]; ok {
+		deprecated = append(deprecated, fmt.Sprintf("the %s annotation is deprecated, use spec.suspend instead", annotationDisableAutoReconcile))
+	}
+	return deprecated
+}
+
+// calculateNextRun returns when the injector runs next, following its cron
+// schedule when it has a valid one and its interval otherwise. Invalid
+// settings fall back to the defaults and are reported by processJob.
+func (bs *BatchScheduler) calculateNextRun(injector corev1alpha1.Injector) time.Time {
+	if schedule, err := parseSchedule(injector.GetSpec()); err == nil && schedule != nil {
+		return schedule.Next(time.Now())
+	}
+
+	interval, _ := bs.reconcileInterval(injector)
 	return time.Now().Add(interval)
 }
 
-func getGroupVersionResource(group, version, resource string) schema.GroupVersionResource {
-	if group == "" && version == "v1" {
-		return schema.GroupVersionResource{
-			Group:    "",
-			Version:  "v1",
-			Resource: resource,
+// parseSchedule returns the cron schedule of the injector in its time zone,
+// or nil when it has none
+func parseSchedule(spec *corev1alpha1.MetadataInjectorSpec) (cron.Schedule, error) {
+	if spec.Schedule == "" {
+		return nil, nil
+	}
+
+	expression := spec.Schedule
+	if spec.TimeZone != "" {
+		if _, err := time.LoadLocation(spec.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", spec.TimeZone, err)
 		}
+		expression = "CRON_TZ=" + spec.TimeZone + " " + expression
 	}
-	return schema.GroupVersionResource{
-		Group:    group,
-		Version:  version,
-		Resource: resource,
+
+	schedule, err := cron.ParseStandard(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec.Schedule, err)
 	}
+	// Same check as the validating webhook, which may not be deployed
+	next := schedule.Next(time.Now())
+	if schedule.Next(next).Sub(next) < corev1alpha1.MinimumInterval {
+		return nil, fmt.Errorf("invalid schedule %q: must leave at least %s between runs", spec.Schedule, corev1alpha1.MinimumInterval)
+	}
+	return schedule, nil
 }
 
-func getNamespaces(namespaces []string) []string {
-	if len(namespaces) == 0 {
-		return []string{""}
+// formatSchedule describes the schedule as reported in the status
+func formatSchedule(spec *corev1alpha1.MetadataInjectorSpec) string {
+	if spec.TimeZone == "" {
+		return spec.Schedule
+	}
+	return fmt.Sprintf("%s (%s)", spec.Schedule, spec.TimeZone)
+}
+
+func (bs *BatchScheduler) resolveMapping(selector corev1alpha1.ResourceSelector) (*meta.RESTMapping, error) {
+	groupKind := schema.GroupKind{Group: selector.Group, Kind: selector.Kind}
+
+	// An empty version list makes the RESTMapper pick the preferred version
+	var versions []string
+	if selector.Version != "" {
+		versions = append(versions, selector.Version)
+	}
+
+	mapping, err := bs.restMapper.RESTMapping(groupKind, versions...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve kind %q: %w", groupKind.String(), err)
 	}
-	return namespaces
+	return mapping, nil
+}
+
+func formatResource(gvr schema.GroupVersionResource) string {
+	return gvr.GroupVersion().String() + "/" + gvr.Resource
+}
+
+// clusterScopeDenied reports whether the mapping is cluster-scoped while the
+// injector is namespaced, and thus not allowed to select it
+func clusterScopeDenied(injector corev1alpha1.Injector, mapping *meta.RESTMapping) bool {
+	return injector.GetNamespace() != "" && mapping.Scope.Name() != meta.RESTScopeNameNamespace
+}
+
+// getNamespaces returns the namespaces the injector targets through the
+// selector, where "" stands for all namespaces, along with the namespaces it
+// selects but is not allowed to target. A namespaced injector is confined to
+// its own namespace unless that namespace is on the cross-namespace allowlist.
+func (bs *BatchScheduler) getNamespaces(ctx context.Context, injector corev1alpha1.Injector, selector corev1alpha1.ResourceSelector) (namespaces, denied []string, err error) {
+	selected, err := bs.selectNamespaces(ctx, selector)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	own := injector.GetNamespace()
+	if own == "" || slices.Contains(bs.crossNamespaceAllowlist, own) {
+		return selected, nil, nil
+	}
+
+	namespaces = []string{}
+	for _, ns := range selected {
+		switch ns {
+		case "", own:
+			if !isNamespaceExcluded(own, selector.ExcludeNamespaces) && !slices.Contains(namespaces, own) {
+				namespaces = append(namespaces, own)
+			}
+		default:
+			denied = append(denied, ns)
+		}
+	}
+	return namespaces, denied, nil
+}
+
+func (bs *BatchScheduler) selectNamespaces(ctx context.Context, selector corev1alpha1.ResourceSelector) ([]string, error) {
+	if len(selector.Namespaces) == 0 && selector.NamespaceSelector == nil {
+		return []string{""}, nil
+	}
+
+	candidates := append([]string{}, selector.Namespaces...)
+	if selector.NamespaceSelector != nil {
+		namespaceSelector, err := metav1.LabelSelectorAsSelector(selector.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector: %w", err)
+		}
+
+		var namespaceList corev1.NamespaceList
+		if err := bs.client.List(ctx, &namespaceList, client.MatchingLabelsSelector{Selector: namespaceSelector}); err != nil {
+			return nil, fmt.Errorf("unable to list namespaces: %w", err)
+		}
+		for _, ns := range namespaceList.Items {
+			if !slices.Contains(candidates, ns.Name) {
+				candidates = append(candidates, ns.Name)
+			}
+		}
+	}
+
+	namespaces := make([]string, 0, len(candidates))
+	for _, ns := range candidates {
+		if !isNamespaceExcluded(ns, selector.ExcludeNamespaces) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces, nil
+}
+
+// matchesSelector reports whether a single object is selected, applying the
+// same filters a scheduled run applies when listing resources
+func (bs *BatchScheduler) matchesSelector(ctx context.Context, injector corev1alpha1.Injector, selector corev1alpha1.ResourceSelector, obj metav1.Object) bool {
+	if !shouldProcessResource(obj.GetName(), selector.Names) {
+		return false
+	}
+	if isNamespaceExcluded(obj.GetNamespace(), selector.ExcludeNamespaces) {
+		return false
+	}
+
+	if selector.LabelSelector != nil {
+		labelSelector, err := metav1.LabelSelectorAsSelector(selector.LabelSelector)
+		if err != nil || !labelSelector.Matches(labels.Set(obj.GetLabels())) {
+			return false
+		}
+	}
+
+	if obj.GetNamespace() == "" {
+		return injector.GetNamespace() == ""
+	}
+	namespaces, _, err := bs.getNamespaces(ctx, injector, selector)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to resolve namespaces", "selector", selector)
+		return false
+	}
+	return slices.Contains(namespaces, "") || slices.Contains(namespaces, obj.GetNamespace())
+}
+
+func isNamespaceExcluded(namespace string, excludeNamespaces []string) bool {
+	return namespace != "" && slices.Contains(excludeNamespaces, namespace)
 }
 
 func shouldProcessResource(name string, targetNames []string) bool {
@@ -88,14 +286,43 @@ func updateMetadata(item *unstructured.Unstructured, labels, annotations map[str
 	}
 }
 
-func (bs *BatchScheduler) updateStatus(ctx context.Context, injector *corev1alpha1.MetadataInjector, intervalStatus string) error {
-	now := metav1.Now()
-	nextRun := calculateNextRun(injector)
+func metadataChanged(original, modified *unstructured.Unstructured) bool {
+	return !maps.Equal(original.GetLabels(), modified.GetLabels()) ||
+		!maps.Equal(original.GetAnnotations(), modified.GetAnnotations())
+}
+
+// diffMetadata lists the labels and annotations added, overwritten and
+// removed between original and modified, leaving out the ownership record.
+// Keys are prefixed with their kind and sorted.
+func diffMetadata(original, modified *unstructured.Unstructured) (added, overwritten, removed []string) {
+	diff := func(before, after map[string]string, kind string) {
+		for k, v := range after {
+			if kind == "annotation" && k == annotationManagedKeys {
+				continue
+			}
+			current, ok := before[k]
+			switch {
+			case !ok:
+				added = append(added, kind+":"+k)
+			case current != v:
+				overwritten = append(overwritten, kind+":"+k)
+			}
+		}
+		for k := range before {
+			if kind == "annotation" && k == annotationManagedKeys {
+				continue
+			}
+			if _, ok := after[k]; !ok {
+				removed = append(removed, kind+":"+k)
+			}
+		}
+	}
 
-	patch := client.MergeFrom(injector.DeepCopy())
-	injector.Status.LastSuccessfulTime = &now
-	injector.Status.NextScheduledTime = &metav1.Time{Time: nextRun}
-	injector.Status.Interval = intervalStatus
+	diff(original.GetLabels(), modified.GetLabels(), "label")
+	diff(original.GetAnnotations(), modified.GetAnnotations(), "annotation")
 
-	return bs.client.Status().Patch(ctx, injector, patch)
+	slices.Sort(added)
+	slices.Sort(overwritten)
+	slices.Sort(removed)
+	return added, overwritten, removed
 }
@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+func namespacedInjector(namespace string) corev1alpha1.Injector {
+	return &corev1alpha1.MetadataInjector{ObjectMeta: metav1.ObjectMeta{Name: "injector", Namespace: namespace}}
+}
+
+func clusterInjector() corev1alpha1.Injector {
+	return &corev1alpha1.ClusterMetadataInjector{ObjectMeta: metav1.ObjectMeta{Name: "injector"}}
+}
+
+func TestGetNamespaces(t *testing.T) {
+	namespaces := []client.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"env": "prod"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Labels: map[string]string{"env": "prod"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sandbox"}},
+	}
+	prod := &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}
+
+	tests := []struct {
+		name       string
+		injector   corev1alpha1.Injector
+		allowlist  []string
+		selector   corev1alpha1.ResourceSelector
+		namespaces []string
+		denied     []string
+	}{
+		{
+			name:       "cluster injector selects all namespaces",
+			injector:   clusterInjector(),
+			namespaces: []string{""},
+		},
+		{
+			name:       "cluster injector selects by label",
+			injector:   clusterInjector(),
+			selector:   corev1alpha1.ResourceSelector{NamespaceSelector: prod},
+			namespaces: []string{"team-a", "team-b"},
+		},
+		{
+			name:       "namespaced injector is confined to its namespace",
+			injector:   namespacedInjector("team-a"),
+			namespaces: []string{"team-a"},
+		},
+		{
+			name:       "namespaced injector is denied other namespaces",
+			injector:   namespacedInjector("team-a"),
+			selector:   corev1alpha1.ResourceSelector{Namespaces: []string{"team-a", "sandbox"}},
+			namespaces: []string{"team-a"},
+			denied:     []string{"sandbox"},
+		},
+		{
+			name:       "namespaced injector is denied namespaces selected by label",
+			injector:   namespacedInjector("team-a"),
+			selector:   corev1alpha1.ResourceSelector{NamespaceSelector: prod},
+			namespaces: []string{"team-a"},
+			denied:     []string{"team-b"},
+		},
+		{
+			name:     "namespaced injector excluding its namespace selects nothing",
+			injector: namespacedInjector("team-a"),
+			selector: corev1alpha1.ResourceSelector{ExcludeNamespaces: []string{"team-a"}},
+		},
+		{
+			name:       "allowlisted namespace reaches other namespaces",
+			injector:   namespacedInjector("team-a"),
+			allowlist:  []string{"team-a"},
+			selector:   corev1alpha1.ResourceSelector{Namespaces: []string{"team-a", "sandbox"}},
+			namespaces: []string{"sandbox", "team-a"},
+		},
+		{
+			name:       "allowlist of another namespace does not apply",
+			injector:   namespacedInjector("team-a"),
+			allowlist:  []string{"team-b"},
+			selector:   corev1alpha1.ResourceSelector{Namespaces: []string{"sandbox"}},
+			namespaces: []string{},
+			denied:     []string{"sandbox"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bs := &BatchScheduler{
+				client:                  fake.NewClientBuilder().WithObjects(namespaces...).Build(),
+				crossNamespaceAllowlist: tt.allowlist,
+			}
+
+			namespaces, denied, err := bs.getNamespaces(context.Background(), tt.injector, tt.selector)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			slices.Sort(namespaces)
+			if !slices.Equal(namespaces, tt.namespaces) {
+				t.Errorf("namespaces = %q, want %q", namespaces, tt.namespaces)
+			}
+			if !slices.Equal(denied, tt.denied) {
+				t.Errorf("denied = %q, want %q", denied, tt.denied)
+			}
+		})
+	}
+}
+
+func TestClusterScopeDenied(t *testing.T) {
+	tests := []struct {
+		name     string
+		injector corev1alpha1.Injector
+		scope    meta.RESTScope
+		want     bool
+	}{
+		{name: "namespaced injector on namespaced kind", injector: namespacedInjector("team-a"), scope: meta.RESTScopeNamespace},
+		{name: "namespaced injector on cluster-scoped kind", injector: namespacedInjector("team-a"), scope: meta.RESTScopeRoot, want: true},
+		{name: "cluster injector on namespaced kind", injector: clusterInjector(), scope: meta.RESTScopeNamespace},
+		{name: "cluster injector on cluster-scoped kind", injector: clusterInjector(), scope: meta.RESTScopeRoot},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clusterScopeDenied(tt.injector, &meta.RESTMapping{Scope: tt.scope}); got != tt.want {
+				t.Errorf("clusterScopeDenied() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		timeZone string
+		wantErr  string
+	}{
+		{name: "no schedule"},
+		{name: "cron expression", schedule: "0 2 * * *"},
+		{name: "cron expression in a time zone", schedule: "0 2 * * *", timeZone: "Asia/Tokyo"},
+		{name: "every descriptor above the minimum", schedule: "@every 30s"},
+		{name: "every descriptor below the minimum", schedule: "@every 1s", wantErr: "at least"},
+		{name: "unparsable expression", schedule: "61 * * * *", wantErr: "invalid schedule"},
+		{name: "unknown time zone", schedule: "0 2 * * *", timeZone: "Mars/Olympus_Mons", wantErr: "invalid time zone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := parseSchedule(&corev1alpha1.MetadataInjectorSpec{Schedule: tt.schedule, TimeZone: tt.timeZone})
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one mentioning %q", err, tt.wantErr)
+			case tt.wantErr == "" && (schedule == nil) != (tt.schedule == ""):
+				t.Errorf("schedule = %v for %q", schedule, tt.schedule)
+			}
+		})
+	}
+}
+
+func TestCalculateNextRun(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		spec     corev1alpha1.MetadataInjectorSpec
+		interval time.Duration
+		check    func(next time.Time) bool
+	}{
+		{
+			name: "cron schedule runs at the hour of its time zone",
+			spec: corev1alpha1.MetadataInjectorSpec{Schedule: "0 2 * * *", TimeZone: "Asia/Tokyo"},
+			check: func(next time.Time) bool {
+				local := next.In(tokyo)
+				return local.Hour() == 2 && local.Minute() == 0 && time.Until(next) <= 24*time.Hour
+			},
+		},
+		{
+			name:     "interval is used without a schedule",
+			spec:     corev1alpha1.MetadataInjectorSpec{Interval: &metav1.Duration{Duration: time.Hour}},
+			interval: time.Hour,
+		},
+		{
+			name:     "schedule below the minimum falls back to the interval",
+			spec:     corev1alpha1.MetadataInjectorSpec{Schedule: "@every 1s"},
+			interval: defaultReconcileInterval,
+		},
+	}
+
+	bs := &BatchScheduler{defaultReconcileInterval: defaultReconcileInterval}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injector := &corev1alpha1.MetadataInjector{
+				ObjectMeta: metav1.ObjectMeta{Name: "injector", Namespace: "team-a"},
+				Spec:       tt.spec,
+			}
+
+			before := time.Now()
+			next := bs.calculateNextRun(injector)
+			if tt.check != nil {
+				if !tt.check(next) {
+					t.Errorf("next run %s does not match the schedule", next)
+				}
+				return
+			}
+			if next.Before(before.Add(tt.interval)) || next.After(time.Now().Add(tt.interval)) {
+				t.Errorf("next run %s, want %s after %s", next, tt.interval, before)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+// serviceAccountRef returns the namespace and name of the service account the
+// injector acts as, or empty strings when it uses the operator's identity
+func serviceAccountRef(injector corev1alpha1.Injector) (namespace, name string, err error) {
+	spec := injector.GetSpec()
+	if spec.ServiceAccountName == "" {
+		return "", "", nil
+	}
+
+	// A MetadataInjector can only use a service account of its own namespace
+	namespace = injector.GetNamespace()
+	if namespace == "" {
+		namespace = spec.ServiceAccountNamespace
+	}
+	if namespace == "" {
+		return "", "", fmt.Errorf("spec.serviceAccountNamespace is required with spec.serviceAccountName")
+	}
+	return namespace, spec.ServiceAccountName, nil
+}
+
+func serviceAccountUsername(namespace, name string) string {
+	return fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name)
+}
+
+// clientFor returns the dynamic client acting on behalf of the injector,
+// impersonating its service account when one is set. Impersonating clients
+// are cached per service account.
+func (bs *BatchScheduler) clientFor(injector corev1alpha1.Injector) (dynamic.Interface, error) {
+	namespace, name, err := serviceAccountRef(injector)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return bs.dynamicClient, nil
+	}
+	if bs.restConfig == nil {
+		return nil, fmt.Errorf("impersonation is not configured")
+	}
+
+	username := serviceAccountUsername(namespace, name)
+
+	bs.clientsMu.Lock()
+	defer bs.clientsMu.Unlock()
+
+	if dc, ok := bs.impersonatingClients[username]; ok {
+		return dc, nil
+	}
+
+	config := rest.CopyConfig(bs.restConfig)
+	config.Impersonate = rest.ImpersonationConfig{UserName: username}
+	dc, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to impersonate %s: %w", username, err)
+	}
+	if bs.impersonatingClients == nil {
+		bs.impersonatingClients = make(map[string]dynamic.Interface)
+	}
+	bs.impersonatingClients[username] = dc
+	return dc, nil
+}
+
+// canPatch reports whether the injector's service account may patch the
+// resource. Injectors without a service account act as the operator and are
+// always allowed.
+func (bs *BatchScheduler) canPatch(ctx context.Context, injector corev1alpha1.Injector, gvr schema.GroupVersionResource, namespace, name string) (bool, error) {
+	saNamespace, saName, err := serviceAccountRef(injector)
+	if err != nil {
+		return false, err
+	}
+	if saName == "" {
+		return true, nil
+	}
+
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   serviceAccountUsername(saNamespace, saName),
+			Groups: []string{"system:serviceaccounts", "system:serviceaccounts:" + saNamespace},
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "patch",
+				Group:     gvr.Group,
+				Version:   gvr.Version,
+				Resource:  gvr.Resource,
+				Name:      name,
+			},
+		},
+	}
+	if err := bs.client.Create(ctx, review); err != nil {
+		return false, fmt.Errorf("unable to review access: %w", err)
+	}
+	return review.Status.Allowed, nil
+}
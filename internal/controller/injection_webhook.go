@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+const injectionWebhookPath = "/mutate-metadata-injection"
+
+// +kubebuilder:webhook:path=/mutate-metadata-injection,mutating=true,failurePolicy=ignore,sideEffects=None,groups="*",resources="*",verbs=create,versions="*",name=minjection.k8s.ruso.dev,admissionReviewVersions=v1,timeoutSeconds=5
+
+// injectionWebhook adds the metadata of every matching injector to
+// resources as they are created, so they are compliant from the start
+type injectionWebhook struct {
+	scheduler *BatchScheduler
+}
+
+// SetupInjectionWebhookWithManager registers the mutating webhook on the
+// manager's webhook server. It must be called after SetupWithManager.
+func (r *MetadataInjectorReconciler) SetupInjectionWebhookWithManager(mgr ctrl.Manager) error {
+	if r.scheduler == nil {
+		return fmt.Errorf("the controller must be set up before the injection webhook")
+	}
+
+	mgr.GetWebhookServer().Register(injectionWebhookPath, &webhook.Admission{
+		Handler: &injectionWebhook{scheduler: r.scheduler},
+	})
+	return nil
+}
+
+func (w *injectionWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create || req.SubResource != "" || ignoredResource(req.Resource.Resource) {
+		return admission.Allowed("")
+	}
+
+	item := &unstructured.Unstructured{}
+	if err := item.UnmarshalJSON(req.Object.Raw); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	// The namespace may be left out of the object and only be known from the request
+	target := item.DeepCopy()
+	if target.GetNamespace() == "" {
+		target.SetNamespace(req.Namespace)
+	}
+
+	gvr := schema.GroupVersionResource{Group: req.Resource.Group, Version: req.Resource.Version, Resource: req.Resource.Resource}
+	groupResource := gvr.GroupResource()
+	injectors, err := w.scheduler.matchingInjectors(ctx, groupResource, target)
+	if err != nil {
+		// Periodic runs remain the backstop, so admission is never blocked
+		log.FromContext(ctx).Error(err, "failed to find matching injectors", "resource", groupResource.String())
+		return admission.Allowed("")
+	}
+	if len(injectors) == 0 {
+		return admission.Allowed("")
+	}
+
+	original := item.DeepCopy()
+	for _, injector := range injectors {
+		allowed, err := w.scheduler.canPatch(ctx, injector, gvr, target.GetNamespace(), target.GetName())
+		if err != nil || !allowed {
+			log.FromContext(ctx).Info("Skipping injector whose service account may not patch the resource",
+				"injector", injectorKey(injector), "resource", groupResource.String(), "reason", err)
+			continue
+		}
+		_, err = applyInjector(ctx, injector, item)
+		var conflict *conflictError
+		if errors.As(err, &conflict) {
+			log.FromContext(ctx).Info("Skipping injector whose keys conflict with the resource",
+				"injector", injectorKey(injector), "keys", conflict.keys)
+			continue
+		}
+		if err != nil {
+			log.FromContext(ctx).Error(err, "failed to inject metadata", "injector", injectorKey(injector))
+			return admission.Allowed("")
+		}
+	}
+	if !metadataChanged(original, item) {
+		return admission.Allowed("")
+	}
+
+	mutated, err := json.Marshal(item)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, mutated)
+}
+
+// ignoredResource reports whether the webhook leaves the resource alone, in
+// case the match conditions of config/webhook are not supported by the cluster:
+// Events and Leases are created too often to be worth a lookup, and the
+// *Review kinds are never persisted
+func ignoredResource(resource string) bool {
+	return resource == "events" || resource == "leases" || strings.HasSuffix(resource, "reviews")
+}
+
+// matchingInjectors returns the injectors selecting obj, ordered by key.
+// Suspended injectors are included, since suspend only stops the scheduled
+// runs, while deleted injectors and dry runs are not.
+func (bs *BatchScheduler) matchingInjectors(ctx context.Context, groupResource schema.GroupResource, obj *unstructured.Unstructured) ([]corev1alpha1.Injector, error) {
+	injectors, err := bs.listInjectors(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []corev1alpha1.Injector
+	for _, injector := range injectors {
+		if !injector.GetDeletionTimestamp().IsZero() || injector.GetSpec().DryRun {
+			continue
+		}
+
+		for _, selector := range injector.GetSpec().Selectors {
+			mapping, err := bs.resolveMapping(selector)
+			if err != nil || mapping.Resource.GroupResource() != groupResource {
+				continue
+			}
+			if bs.matchesSelector(ctx, injector, selector, obj) {
+				matching = append(matching, injector)
+				break
+			}
+		}
+	}
+
+	return matching, nil
+}
@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+func TestInjectionWebhookHandle(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		resource  string
+		namespace string
+		labels    map[string]string
+		modify    func(spec *corev1alpha1.MetadataInjectorSpec)
+		patched   bool
+	}{
+		{name: "matching resource is mutated on create", patched: true},
+		{name: "updates are left alone", operation: admissionv1.Update},
+		{name: "events are ignored", resource: "events"},
+		{name: "resources outside the injector's namespace are left alone", namespace: "team-b"},
+		{
+			name:   "dry runs never mutate",
+			modify: func(spec *corev1alpha1.MetadataInjectorSpec) { spec.DryRun = true },
+		},
+		{
+			name:    "suspended injectors keep mutating",
+			modify:  func(spec *corev1alpha1.MetadataInjectorSpec) { spec.Suspend = true },
+			patched: true,
+		},
+		{
+			name:   "conflicts under the Fail policy are left alone",
+			labels: map[string]string{"team": "payments"},
+			modify: func(spec *corev1alpha1.MetadataInjectorSpec) {
+				spec.ConflictPolicy = corev1alpha1.ConflictPolicyFail
+			},
+		},
+		{
+			name:   "resources already holding the metadata are not patched",
+			labels: map[string]string{"team": "platform"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			if err := clientgoscheme.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+			if err := corev1alpha1.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+
+			injector := watchingInjector()
+			injector.Spec.Watch = false
+			if tt.modify != nil {
+				tt.modify(&injector.Spec)
+			}
+			w := &injectionWebhook{scheduler: &BatchScheduler{
+				client:     fake.NewClientBuilder().WithScheme(scheme).WithObjects(injector).Build(),
+				restMapper: testRESTMapper(),
+			}}
+
+			operation, resource, namespace := tt.operation, tt.resource, tt.namespace
+			if operation == "" {
+				operation = admissionv1.Create
+			}
+			if resource == "" {
+				resource = "configmaps"
+			}
+			if namespace == "" {
+				namespace = "team-a"
+			}
+			raw, err := json.Marshal(&corev1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+				ObjectMeta: metav1.ObjectMeta{Name: "settings", Labels: tt.labels},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp := w.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: operation,
+				Resource:  metav1.GroupVersionResource{Version: "v1", Resource: resource},
+				Namespace: namespace,
+				Name:      "settings",
+				Object:    runtime.RawExtension{Raw: raw},
+			}})
+			if !resp.Allowed {
+				t.Fatalf("request denied: %v", resp.Result)
+			}
+			if patched := len(resp.Patches) > 0; patched != tt.patched {
+				t.Errorf("patched = %v, want %v: %v", patched, tt.patched, resp.Patches)
+			}
+		})
+	}
+}
@@ -18,28 +18,45 @@ package controller
 
 import (
 	"context"
-	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	crcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
 )
 
+// injectorPredicates ignores the status updates made by the runs themselves,
+// so an injector is only reconciled when its spec, annotations or deletion
+// state change
+var injectorPredicates = predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{})
+
 // MetadataInjectorReconciler reconciles a MetadataInjector object
 // +kubebuilder:rbac:groups=core.k8s.ruso.dev,resources=metadatainjectors,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core.k8s.ruso.dev,resources=metadatainjectors/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=core.k8s.ruso.dev,resources=metadatainjectors/finalizers,verbs=update
 // +kubebuilder:rbac:groups="*",resources="*",verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=impersonate
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=create;patch;delete
 type MetadataInjectorReconciler struct {
 	client.Client
 	Scheme        *runtime.Scheme
 	DynamicClient dynamic.Interface
-	scheduler     *BatchScheduler
+	// Options tunes the scheduler and the controllers
+	Options   Options
+	scheduler *BatchScheduler
+	watcher   *ResourceWatcher
 }
 
 func (r *MetadataInjectorReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -49,24 +66,102 @@ func (r *MetadataInjectorReconciler) Reconcile(ctx context.Context, req ctrl.Req
 	if err := r.Get(ctx, req.NamespacedName, &injector); err != nil {
 		if errors.IsNotFound(err) {
 			log.Info("MetadataInjector resource not found. Ignoring since object must be deleted")
+			r.scheduler.Unschedule(req.NamespacedName)
+			r.watcher.Unregister(ctx, req.NamespacedName.String())
+			forgetInjectorMetrics(req.NamespacedName.String())
 			return ctrl.Result{}, nil
 		}
 		log.Error(err, "Failed to get MetadataInjector")
 		return ctrl.Result{}, err
 	}
 
+	return r.reconcileInjector(ctx, &injector)
+}
+
+// reconcileInjector holds the reconciliation shared by MetadataInjector and
+// ClusterMetadataInjector once the object has been fetched
+func (r *MetadataInjectorReconciler) reconcileInjector(ctx context.Context, injector corev1alpha1.Injector) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	key := injectorKey(injector)
+
+	if !injector.GetDeletionTimestamp().IsZero() {
+		r.scheduler.Unschedule(client.ObjectKeyFromObject(injector))
+		r.watcher.Unregister(ctx, key)
+		if controllerutil.ContainsFinalizer(injector, finalizerName) {
+			if err := r.scheduler.cleanupJob(ctx, injector); err != nil {
+				log.Error(err, "Failed to remove injected metadata")
+				return ctrl.Result{}, err
+			}
+
+			controllerutil.RemoveFinalizer(injector, finalizerName)
+			if err := r.Update(ctx, injector); err != nil {
+				log.Error(err, "Failed to remove finalizer")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if controllerutil.AddFinalizer(injector, finalizerName) {
+		if err := r.Update(ctx, injector); err != nil {
+			log.Error(err, "Failed to add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Dry runs never write, so they are left to the scheduled runs
+	if injector.GetSpec().Watch && !injector.GetSpec().DryRun {
+		r.watcher.Register(ctx, injector)
+	} else {
+		r.watcher.Unregister(ctx, key)
+	}
+
+	// Runs of an unchanged spec are left to the scheduler, which dispatches
+	// them once status.nextScheduledTime has passed
+	if !specChanged(injector) {
+		if shouldProcess(injector) {
+			r.scheduler.Schedule(client.ObjectKeyFromObject(injector), injector.GetStatus().NextScheduledTime.Time)
+		} else {
+			r.scheduler.Unschedule(client.ObjectKeyFromObject(injector))
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Suspending an injector changes its spec too, which must not trigger a run
+	if !shouldProcess(injector) {
+		r.scheduler.Unschedule(client.ObjectKeyFromObject(injector))
+		return ctrl.Result{}, nil
+	}
+
+	// A schedule defines when the injector writes, so a new spec waits for it
+	if injector.GetSpec().Schedule != "" {
+		r.scheduler.Schedule(client.ObjectKeyFromObject(injector), r.scheduler.calculateNextRun(injector))
+		return ctrl.Result{}, nil
+	}
+
 	// Process immediately
 	job := ReconcileJob{
-		Injector: injector.DeepCopy(),
-		NextRun:  calculateNextRun(&injector),
+		Injector: injector.DeepCopyObject().(corev1alpha1.Injector),
+		NextRun:  r.scheduler.calculateNextRun(injector),
 	}
-	if err := r.scheduler.processJob(ctx, job); err != nil {
+	ran, err := r.scheduler.runJob(ctx, job)
+	if err != nil {
 		log.Error(err, "Failed to process job")
 		return ctrl.Result{}, err
 	}
+	if !ran {
+		// A scheduled run of the previous spec is in progress
+		return ctrl.Result{RequeueAfter: inFlightRequeueDelay}, nil
+	}
+	return ctrl.Result{}, nil
+}
 
-	// Requeue based on the next scheduled run
-	return ctrl.Result{RequeueAfter: time.Until(job.NextRun)}, nil
+// specChanged reports whether the injector has not run since its spec last
+// changed, or has never run at all
+func specChanged(injector corev1alpha1.Injector) bool {
+	status := injector.GetStatus()
+	ready := meta.FindStatusCondition(status.Conditions, conditionTypeReady)
+	return ready == nil || ready.ObservedGeneration != injector.GetGeneration() || status.NextScheduledTime == nil
 }
 
 func (r *MetadataInjectorReconciler) SetupWithManager(mgr ctrl.Manager) error {
@@ -75,11 +170,26 @@ func (r *MetadataInjectorReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
+	metadataClient, err := metadata.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+
 	r.DynamicClient = dynamicClient
-	r.scheduler = NewBatchScheduler(r.Client, dynamicClient, defaultBatchInterval, defaultWorkers)
-	r.scheduler.Start()
+	r.Options = r.Options.withDefaults()
+	r.scheduler = NewBatchScheduler(r.Client, dynamicClient, mgr.GetRESTMapper(), r.Options)
+	r.scheduler.recorder = mgr.GetEventRecorderFor(fieldManager)
+	r.scheduler.restConfig = mgr.GetConfig()
+	if err := mgr.Add(r.scheduler); err != nil {
+		return err
+	}
+	r.watcher = NewResourceWatcher(r.scheduler, metadataClient)
+	if err := mgr.Add(r.watcher); err != nil {
+		return err
+	}
 
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1alpha1.MetadataInjector{}).
+		For(&corev1alpha1.MetadataInjector{}, builder.WithPredicates(injectorPredicates)).
+		WithOptions(crcontroller.Options{MaxConcurrentReconciles: r.Options.MaxConcurrentReconciles}).
 		Complete(r)
 }
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "metadata_injector"
+
+var (
+	resourcesMatched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "resources_matched_total",
+		Help:      "Number of resources matched by an injector's selectors",
+	}, []string{"injector", "resource"})
+
+	resourcesPatched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "resources_patched_total",
+		Help:      "Number of resources whose metadata an injector patched",
+	}, []string{"injector", "resource"})
+
+	resourcesInSync = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "resources_in_sync_total",
+		Help:      "Number of matched resources left untouched because their metadata was already in sync",
+	}, []string{"injector", "resource"})
+
+	resourcesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "resources_failed_total",
+		Help:      "Number of matched resources an injector failed to patch",
+	}, []string{"injector", "resource"})
+
+	resourcesConflicted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "resources_conflicted_total",
+		Help:      "Number of matched resources left untouched because of conflicting keys under the Fail conflict policy",
+	}, []string{"injector", "resource"})
+
+	jobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "job_duration_seconds",
+		Help:      "Time taken to process all the selectors of an injector",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"injector"})
+
+	jobStartDelay = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "job_start_delay_seconds",
+		Help:      "Time between the scheduled run of an injector and the start of its job",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"injector"})
+
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "scheduler_queue_depth",
+		Help:      "Number of injector runs that are due and waiting for a worker",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		resourcesMatched,
+		resourcesPatched,
+		resourcesInSync,
+		resourcesFailed,
+		resourcesConflicted,
+		jobDuration,
+		jobStartDelay,
+		queueDepth,
+	)
+}
+
+// observeItem records the outcome of processing a single matched resource
+func observeItem(injector string, gvr schema.GroupVersionResource, changed bool, err error) {
+	resource := formatResource(gvr)
+	resourcesMatched.WithLabelValues(injector, resource).Inc()
+	var conflict *conflictError
+	switch {
+	case errors.As(err, &conflict):
+		resourcesConflicted.WithLabelValues(injector, resource).Inc()
+	case err != nil:
+		resourcesFailed.WithLabelValues(injector, resource).Inc()
+	case changed:
+		resourcesPatched.WithLabelValues(injector, resource).Inc()
+	default:
+		resourcesInSync.WithLabelValues(injector, resource).Inc()
+	}
+}
+
+// observeJobStart records how late the job started compared with its scheduled run
+func observeJobStart(injector string, scheduledAt time.Time) {
+	if scheduledAt.IsZero() {
+		return
+	}
+	jobStartDelay.WithLabelValues(injector).Observe(max(time.Since(scheduledAt), 0).Seconds())
+}
+
+// forgetInjectorMetrics drops the series of a deleted injector
+func forgetInjectorMetrics(injector string) {
+	labels := prometheus.Labels{"injector": injector}
+	for _, vec := range []*prometheus.CounterVec{resourcesMatched, resourcesPatched, resourcesInSync, resourcesFailed, resourcesConflicted} {
+		vec.DeletePartialMatch(labels)
+	}
+	jobDuration.DeletePartialMatch(labels)
+	jobStartDelay.DeletePartialMatch(labels)
+}
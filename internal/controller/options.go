@@ -0,0 +1,63 @@
+package controller
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Options tunes the scheduler and the controllers. It can be loaded from the
+// operator's config file; zero values fall back to the defaults.
+type Options struct {
+	// Workers is the number of injectors the scheduler processes concurrently
+	Workers int `json:"workers,omitempty"`
+
+	// BatchInterval is how often the scheduler looks for injectors that are due
+	BatchInterval metav1.Duration `json:"batchInterval,omitempty"`
+
+	// DefaultReconcileInterval is the interval of the injectors that set none
+	DefaultReconcileInterval metav1.Duration `json:"defaultReconcileInterval,omitempty"`
+
+	// MaxConcurrentReconciles is the number of injectors each controller reconciles concurrently
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+
+	// CrossNamespaceAllowlist lists the namespaces whose MetadataInjectors may
+	// target resources in other namespaces
+	CrossNamespaceAllowlist []string `json:"crossNamespaceAllowlist,omitempty"`
+
+	// TargetEvents records an event on every resource whose metadata is changed
+	TargetEvents bool `json:"targetEvents,omitempty"`
+
+	// Namespace is the namespace the operator runs in, where the dry-run plans
+	// of ClusterMetadataInjectors are stored
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// DefaultOptions returns the options used when nothing is configured
+func DefaultOptions() Options {
+	return Options{
+		Workers:                  defaultWorkers,
+		BatchInterval:            metav1.Duration{Duration: defaultBatchInterval},
+		DefaultReconcileInterval: metav1.Duration{Duration: defaultReconcileInterval},
+		MaxConcurrentReconciles:  defaultMaxConcurrentReconciles,
+	}
+}
+
+// withDefaults fills the unset options with their default value
+func (o Options) withDefaults() Options {
+	defaults := DefaultOptions()
+	if o.Workers <= 0 {
+		o.Workers = defaults.Workers
+	}
+	if o.BatchInterval.Duration <= 0 {
+		o.BatchInterval = defaults.BatchInterval
+	}
+	if o.DefaultReconcileInterval.Duration <= 0 {
+		o.DefaultReconcileInterval = defaults.DefaultReconcileInterval
+	}
+	if o.MaxConcurrentReconciles <= 0 {
+		o.MaxConcurrentReconciles = defaults.MaxConcurrentReconciles
+	}
+	if o.Namespace == "" {
+		o.Namespace = defaultNamespace
+	}
+	return o
+}
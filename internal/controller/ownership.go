@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// injectorKey identifies an injector inside the managed keys record
+func injectorKey(injector client.Object) string {
+	return client.ObjectKeyFromObject(injector).String()
+}
+
+// getManagedKeys reads the managed keys record stamped on the item, keyed by injector
+func getManagedKeys(item *unstructured.Unstructured) (map[string]ManagedKeys, error) {
+	record := make(map[string]ManagedKeys)
+
+	raw := item.GetAnnotations()[annotationManagedKeys]
+	if raw == "" {
+		return record, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &record); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", annotationManagedKeys, err)
+	}
+	return record, nil
+}
+
+// setManagedKeys stamps the managed keys record on the item, dropping the
+// annotation altogether once no injector owns any key
+func setManagedKeys(item *unstructured.Unstructured, record map[string]ManagedKeys) error {
+	annotations := item.GetAnnotations()
+	if len(record) == 0 {
+		delete(annotations, annotationManagedKeys)
+		item.SetAnnotations(annotations)
+		return nil
+	}
+
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("unable to encode managed keys: %w", err)
+	}
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[annotationManagedKeys] = string(raw)
+	item.SetAnnotations(annotations)
+	return nil
+}
+
+// addedKeys returns the desired labels and annotations the injector adds or
+// changes on the item, along with the ones it already owns. Keys the item
+// already holds with the desired value were not written by the injector, so
+// they are never claimed and thus never pruned or released by it.
+func addedKeys(item *unstructured.Unstructured, owned ManagedKeys, labels, annotations map[string]string) (map[string]string, map[string]string) {
+	filter := func(desired, existing map[string]string, ownedKeys []string) map[string]string {
+		added := make(map[string]string)
+		for k, v := range desired {
+			if current, ok := existing[k]; !ok || current != v || slices.Contains(ownedKeys, k) {
+				added[k] = v
+			}
+		}
+		return added
+	}
+
+	return filter(labels, item.GetLabels(), owned.Labels), filter(annotations, item.GetAnnotations(), owned.Annotations)
+}
+
+// claimKeys records the given labels and annotations as owned by owner,
+// keeping the keys it already owned
+func claimKeys(record map[string]ManagedKeys, owner string, labels, annotations map[string]string) {
+	owned := record[owner]
+	owned.Labels = mergeKeys(owned.Labels, labels)
+	owned.Annotations = mergeKeys(owned.Annotations, annotations)
+
+	if len(owned.Labels) == 0 && len(owned.Annotations) == 0 {
+		delete(record, owner)
+		return
+	}
+	record[owner] = owned
+}
+
+// pruneKeys removes the keys owner holds in the record but no longer declares,
+// except those another injector also claims, and forgets them in the record
+func pruneKeys(item *unstructured.Unstructured, record map[string]ManagedKeys, owner string, labels, annotations map[string]string) {
+	owned, ok := record[owner]
+	if !ok {
+		return
+	}
+
+	currentLabels := item.GetLabels()
+	for _, k := range owned.Labels {
+		if _, declared := labels[k]; !declared && !ownedByOthers(record, owner, k, ownedLabels) {
+			delete(currentLabels, k)
+		}
+	}
+	item.SetLabels(currentLabels)
+
+	currentAnnotations := item.GetAnnotations()
+	for _, k := range owned.Annotations {
+		if _, declared := annotations[k]; !declared && !ownedByOthers(record, owner, k, ownedAnnotations) {
+			delete(currentAnnotations, k)
+		}
+	}
+	item.SetAnnotations(currentAnnotations)
+
+	owned.Labels = retainDeclared(owned.Labels, labels)
+	owned.Annotations = retainDeclared(owned.Annotations, annotations)
+	if len(owned.Labels) == 0 && len(owned.Annotations) == 0 {
+		delete(record, owner)
+		return
+	}
+	record[owner] = owned
+}
+
+func retainDeclared(keys []string, values map[string]string) []string {
+	return slices.DeleteFunc(slices.Clone(keys), func(k string) bool {
+		_, declared := values[k]
+		return !declared
+	})
+}
+
+func mergeKeys(keys []string, values map[string]string) []string {
+	merged := slices.Clone(keys)
+	for k := range values {
+		if !slices.Contains(merged, k) {
+			merged = append(merged, k)
+		}
+	}
+	slices.Sort(merged)
+	return merged
+}
+
+// ownedByOthers reports whether any injector other than owner claims key
+func ownedByOthers(record map[string]ManagedKeys, owner, key string, keysOf func(ManagedKeys) []string) bool {
+	for other, owned := range record {
+		if other != owner && slices.Contains(keysOf(owned), key) {
+			return true
+		}
+	}
+	return false
+}
+
+func ownedLabels(owned ManagedKeys) []string {
+	return owned.Labels
+}
+
+func ownedAnnotations(owned ManagedKeys) []string {
+	return owned.Annotations
+}
+
+// findConflicts returns the keys about to be overwritten with a different
+// value while another injector owns them
+func findConflicts(item *unstructured.Unstructured, record map[string]ManagedKeys, owner string, labels, annotations map[string]string) []string {
+	var conflicts []string
+
+	currentLabels := item.GetLabels()
+	for k, v := range labels {
+		if current, ok := currentLabels[k]; ok && current != v && ownedByOthers(record, owner, k, ownedLabels) {
+			conflicts = append(conflicts, "label:"+k)
+		}
+	}
+
+	currentAnnotations := item.GetAnnotations()
+	for k, v := range annotations {
+		if current, ok := currentAnnotations[k]; ok && current != v && ownedByOthers(record, owner, k, ownedAnnotations) {
+			conflicts = append(conflicts, "annotation:"+k)
+		}
+	}
+
+	slices.Sort(conflicts)
+	return conflicts
+}
+
+// releaseKeys removes the keys owned by owner from the item, except those
+// another injector also claims, and drops owner from the record. Items with
+// no record for owner are left untouched: the injector never added anything
+// to them.
+func releaseKeys(item *unstructured.Unstructured, owner string) (bool, error) {
+	record, err := getManagedKeys(item)
+	if err != nil {
+		return false, err
+	}
+
+	owned, ok := record[owner]
+	if !ok {
+		return false, nil
+	}
+	delete(record, owner)
+
+	currentLabels := item.GetLabels()
+	for _, k := range owned.Labels {
+		if !ownedByOthers(record, owner, k, ownedLabels) {
+			delete(currentLabels, k)
+		}
+	}
+	item.SetLabels(currentLabels)
+
+	currentAnnotations := item.GetAnnotations()
+	for _, k := range owned.Annotations {
+		if !ownedByOthers(record, owner, k, ownedAnnotations) {
+			delete(currentAnnotations, k)
+		}
+	}
+	item.SetAnnotations(currentAnnotations)
+
+	return true, setManagedKeys(item, record)
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+// patchMetadata sends a JSON merge patch holding only the metadata changes
+// between original and modified, so concurrent writers of other fields are
+// left alone. See metadataPatch for when a resourceVersion precondition is
+// involved.
+func (bs *BatchScheduler) patchMetadata(ctx context.Context, injector corev1alpha1.Injector, gvr schema.GroupVersionResource, original, modified *unstructured.Unstructured) error {
+	data, err := metadataPatch(original, modified).Data(modified)
+	if err != nil {
+		return fmt.Errorf("unable to compute patch: %w", err)
+	}
+
+	dc, err := bs.clientFor(injector)
+	if err != nil {
+		return err
+	}
+	_, err = dc.Resource(gvr).Namespace(modified.GetNamespace()).Patch(
+		ctx,
+		modified.GetName(),
+		types.MergePatchType,
+		data,
+		metav1.PatchOptions{FieldManager: fieldManager},
+	)
+	return err
+}
+
+// metadataPatch builds the merge patch from original to modified. The managed
+// keys record is a single annotation replaced as a whole, so a patch changing
+// it carries the original resourceVersion: two injectors writing the same
+// resource at once would otherwise drop each other's ownership record.
+func metadataPatch(original, modified *unstructured.Unstructured) client.Patch {
+	if original.GetAnnotations()[annotationManagedKeys] != modified.GetAnnotations()[annotationManagedKeys] {
+		return client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{})
+	}
+	return client.MergeFrom(original)
+}
+
+// updateTarget applies mutate to the item and patches the metadata it
+// changed, returning the item as it was before mutate. When the patch fails
+// on a conflict, the item is fetched again and mutate applied once more.
+func (bs *BatchScheduler) updateTarget(ctx context.Context, injector corev1alpha1.Injector, gvr schema.GroupVersionResource, item *unstructured.Unstructured, mutate func(*unstructured.Unstructured) error) (*unstructured.Unstructured, bool, error) {
+	var original *unstructured.Unstructured
+	var changed, refetch bool
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if refetch {
+			dc, err := bs.clientFor(injector)
+			if err != nil {
+				return err
+			}
+			current, err := dc.Resource(gvr).Namespace(item.GetNamespace()).Get(ctx, item.GetName(), metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			*item = *current
+		}
+		refetch = true
+
+		original = item.DeepCopy()
+		if err := mutate(item); err != nil {
+			return err
+		}
+		changed = metadataChanged(original, item)
+		if !changed {
+			return nil
+		}
+		return bs.patchMetadata(ctx, injector, gvr, original, item)
+	})
+	return original, changed, err
+}
+
+// withoutPresentKeys drops the desired keys the item already sets to another
+// value, returning the remaining keys and the ones that were dropped. Keys the
+// injector already owns are not conflicts, so changing their value in the spec
+// is still applied.
+func withoutPresentKeys(item *unstructured.Unstructured, owned ManagedKeys, labels, annotations map[string]string) (map[string]string, map[string]string, []string) {
+	var conflicts []string
+	filter := func(desired, existing map[string]string, ownedKeys []string, kind string) map[string]string {
+		kept := maps.Clone(desired)
+		for k, v := range desired {
+			if current, ok := existing[k]; ok && current != v && !slices.Contains(ownedKeys, k) {
+				delete(kept, k)
+				conflicts = append(conflicts, kind+":"+k)
+			}
+		}
+		return kept
+	}
+
+	labels = filter(labels, item.GetLabels(), owned.Labels, "label")
+	annotations = filter(annotations, item.GetAnnotations(), owned.Annotations, "annotation")
+
+	slices.Sort(conflicts)
+	return labels, annotations, conflicts
+}
+
+// conflictError is returned for items left untouched under the Fail conflict policy
+type conflictError struct {
+	keys []string
+}
+
+func (e *conflictError) Error() string {
+	return fmt.Sprintf("conflicting keys %s", strings.Join(e.keys, ", "))
+}
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestMetadataPatch(t *testing.T) {
+	base := func() *unstructured.Unstructured {
+		item := &unstructured.Unstructured{}
+		item.SetName("target")
+		item.SetResourceVersion("42")
+		item.SetAnnotations(map[string]string{annotationManagedKeys: `{"team-a/injector":{"labels":["team"]}}`})
+		return item
+	}
+
+	tests := []struct {
+		name   string
+		modify func(item *unstructured.Unstructured)
+		locked bool
+	}{
+		{
+			name:   "label change alone is not locked",
+			modify: func(item *unstructured.Unstructured) { item.SetLabels(map[string]string{"team": "platform"}) },
+		},
+		{
+			name: "managed keys change is locked",
+			modify: func(item *unstructured.Unstructured) {
+				item.SetLabels(map[string]string{"tier": "backend"})
+				item.SetAnnotations(map[string]string{annotationManagedKeys: `{"team-a/injector":{"labels":["team","tier"]}}`})
+			},
+			locked: true,
+		},
+		{
+			name:   "managed keys removal is locked",
+			modify: func(item *unstructured.Unstructured) { item.SetAnnotations(nil) },
+			locked: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := base()
+			modified := original.DeepCopy()
+			tt.modify(modified)
+
+			data, err := metadataPatch(original, modified).Data(modified)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var patch struct {
+				Metadata map[string]any `json:"metadata"`
+			}
+			if err := json.Unmarshal(data, &patch); err != nil {
+				t.Fatal(err)
+			}
+			if _, locked := patch.Metadata["resourceVersion"]; locked != tt.locked {
+				t.Errorf("patch %s: locked = %v, want %v", data, locked, tt.locked)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+// planItem records in plan the metadata changes the injector would make to
+// the item, without writing them, and reports whether there are any and which
+// desired keys would be skipped
+func planItem(ctx context.Context, injector corev1alpha1.Injector, gvr schema.GroupVersionResource, item *unstructured.Unstructured, plan *corev1alpha1.DryRunPlan) (bool, []string, error) {
+	original := item.DeepCopy()
+	skipped, err := applyInjector(ctx, injector, item)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if !metadataChanged(original, item) {
+		return false, skipped, nil
+	}
+
+	added, overwritten, removed := diffMetadata(original, item)
+	plan.Total++
+	plan.Changes = append(plan.Changes, corev1alpha1.PlannedChange{
+		Resource:    formatResource(gvr),
+		Namespace:   item.GetNamespace(),
+		Name:        item.GetName(),
+		Added:       added,
+		Overwritten: overwritten,
+		Removed:     removed,
+	})
+	return true, skipped, nil
+}
+
+// savePlan moves the planned changes of a dry run into a ConfigMap when there
+// are too many for the status, and deletes the ConfigMap of a previous plan
+// once it is no longer needed. If the ConfigMap cannot be written, the status
+// keeps the first changes only.
+func (bs *BatchScheduler) savePlan(ctx context.Context, injector corev1alpha1.Injector, plan *corev1alpha1.DryRunPlan) error {
+	var errs error
+	if plan != nil && len(plan.Changes) > maxPlannedChangesInStatus {
+		if err := bs.applyPlanConfigMap(ctx, injector, plan.Changes); err != nil {
+			plan.Changes = plan.Changes[:maxPlannedChangesInStatus]
+			errs = err
+		} else {
+			plan.ConfigMapName = planConfigMapName(injector)
+			plan.Changes = nil
+		}
+	}
+
+	previous := injector.GetStatus().Plan
+	if previous == nil || previous.ConfigMapName == "" || (plan != nil && plan.ConfigMapName != "") {
+		return errs
+	}
+
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:      previous.ConfigMapName,
+		Namespace: bs.planNamespace(injector),
+	}}
+	if err := bs.client.Delete(ctx, configMap); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete the plan ConfigMap: %w", err)
+	}
+	return errs
+}
+
+// applyPlanConfigMap writes the changes to the plan ConfigMap with a
+// server-side apply, owned by the injector so it is garbage collected with it
+func (bs *BatchScheduler) applyPlanConfigMap(ctx context.Context, injector corev1alpha1.Injector, changes []corev1alpha1.PlannedChange) error {
+	data, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode the plan: %w", err)
+	}
+
+	configMap := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      planConfigMapName(injector),
+			Namespace: bs.planNamespace(injector),
+		},
+		Data: map[string]string{planConfigMapKey: string(data)},
+	}
+	if err := controllerutil.SetControllerReference(injector, configMap, bs.client.Scheme()); err != nil {
+		return err
+	}
+
+	if err := bs.client.Patch(ctx, configMap, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership); err != nil {
+		return fmt.Errorf("unable to write the plan ConfigMap: %w", err)
+	}
+	return nil
+}
+
+// planConfigMapName is unique per injector within the namespace it is stored in
+func planConfigMapName(injector corev1alpha1.Injector) string {
+	return strings.ToLower(injectorKind(injector)) + "-" + injector.GetName() + "-plan"
+}
+
+// planNamespace is the injector's own namespace, or the operator's for a
+// ClusterMetadataInjector
+func (bs *BatchScheduler) planNamespace(injector corev1alpha1.Injector) string {
+	if injector.GetNamespace() != "" {
+		return injector.GetNamespace()
+	}
+	return bs.namespace
+}
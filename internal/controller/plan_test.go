@@ -0,0 +1,212 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+func TestDiffMetadata(t *testing.T) {
+	original := newItem(t, map[string]string{"team": "payments", "stale": "yes", "app": "api"}, nil)
+	original.SetAnnotations(map[string]string{"note": "old"})
+	modified := original.DeepCopy()
+	modified.SetLabels(map[string]string{"team": "platform", "tier": "backend", "app": "api"})
+	modified.SetAnnotations(map[string]string{"note": "old", "owner": "sre", annotationManagedKeys: `{}`})
+
+	added, overwritten, removed := diffMetadata(original, modified)
+	if want := []string{"annotation:owner", "label:tier"}; !slices.Equal(added, want) {
+		t.Errorf("added = %q, want %q", added, want)
+	}
+	if want := []string{"label:team"}; !slices.Equal(overwritten, want) {
+		t.Errorf("overwritten = %q, want %q", overwritten, want)
+	}
+	if want := []string{"label:stale"}; !slices.Equal(removed, want) {
+		t.Errorf("removed = %q, want %q", removed, want)
+	}
+}
+
+func TestPlanItem(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  corev1alpha1.ConflictPolicy
+		labels  map[string]string
+		planned bool
+		change  corev1alpha1.PlannedChange
+		skipped []string
+	}{
+		{
+			name:    "changes are planned",
+			labels:  map[string]string{"team": "payments"},
+			planned: true,
+			change:  corev1alpha1.PlannedChange{Added: []string{"label:tier"}, Overwritten: []string{"label:team"}},
+		},
+		{
+			name:   "resources in sync are not planned",
+			labels: map[string]string{"team": "platform", "tier": "backend"},
+		},
+		{
+			name:    "preserved keys are reported and left out of the plan",
+			policy:  corev1alpha1.ConflictPolicyIfNotPresent,
+			labels:  map[string]string{"team": "payments"},
+			planned: true,
+			change:  corev1alpha1.PlannedChange{Added: []string{"label:tier"}},
+			skipped: []string{"label:team"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injector := namespacedInjector("team-a").(*corev1alpha1.MetadataInjector)
+			injector.Spec = corev1alpha1.MetadataInjectorSpec{
+				Inject:         corev1alpha1.MetadataInjection{Labels: map[string]string{"team": "platform", "tier": "backend"}},
+				ConflictPolicy: tt.policy,
+				DryRun:         true,
+			}
+			item := newItem(t, tt.labels, nil)
+			var plan corev1alpha1.DryRunPlan
+
+			planned, skipped, err := planItem(context.Background(), injector, configMapsResource, item, &plan)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if planned != tt.planned {
+				t.Errorf("planned = %v, want %v", planned, tt.planned)
+			}
+			if !slices.Equal(skipped, tt.skipped) {
+				t.Errorf("skipped = %q, want %q", skipped, tt.skipped)
+			}
+			if !tt.planned {
+				if plan.Total != 0 || len(plan.Changes) != 0 {
+					t.Errorf("unexpected plan: %+v", plan)
+				}
+				return
+			}
+			if plan.Total != 1 || len(plan.Changes) != 1 {
+				t.Fatalf("plan = %+v, want a single change", plan)
+			}
+			change := plan.Changes[0]
+			if change.Resource != formatResource(configMapsResource) || change.Namespace != "team-a" || change.Name != "target" {
+				t.Errorf("change targets %s %s/%s", change.Resource, change.Namespace, change.Name)
+			}
+			if !slices.Equal(change.Added, tt.change.Added) || !slices.Equal(change.Overwritten, tt.change.Overwritten) || len(change.Removed) > 0 {
+				t.Errorf("change = %+v, want %+v", change, tt.change)
+			}
+		})
+	}
+}
+
+func TestSavePlan(t *testing.T) {
+	plan := func(changes int) *corev1alpha1.DryRunPlan {
+		plan := &corev1alpha1.DryRunPlan{Total: int32(changes)}
+		for i := range changes {
+			plan.Changes = append(plan.Changes, corev1alpha1.PlannedChange{Resource: "v1/configmaps", Namespace: "team-a", Name: fmt.Sprint(i)})
+		}
+		return plan
+	}
+
+	tests := []struct {
+		name       string
+		plan       *corev1alpha1.DryRunPlan
+		previous   bool
+		applyErr   error
+		wantErr    bool
+		configMap  bool
+		inStatus   int
+		keepsOther bool
+	}{
+		{name: "small plan stays in the status", plan: plan(3), inStatus: 3},
+		{name: "large plan moves to a ConfigMap", plan: plan(maxPlannedChangesInStatus + 1), configMap: true},
+		{
+			name:     "large plan is truncated when the ConfigMap cannot be written",
+			plan:     plan(maxPlannedChangesInStatus + 1),
+			applyErr: errors.New("forbidden"),
+			wantErr:  true,
+			inStatus: maxPlannedChangesInStatus,
+		},
+		{name: "previous ConfigMap is deleted once the plan fits the status", plan: plan(3), previous: true, inStatus: 3},
+		{name: "previous ConfigMap is deleted when dry run is turned off", previous: true},
+		{
+			name:       "previous ConfigMap is kept while the plan still needs it",
+			plan:       plan(maxPlannedChangesInStatus + 1),
+			previous:   true,
+			configMap:  true,
+			keepsOther: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			if err := corev1.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+			if err := corev1alpha1.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+
+			injector := namespacedInjector("team-a").(*corev1alpha1.MetadataInjector)
+			injector.UID = "uid"
+			name := planConfigMapName(injector)
+			builder := fake.NewClientBuilder().WithScheme(scheme)
+			if tt.previous {
+				injector.Status.Plan = &corev1alpha1.DryRunPlan{ConfigMapName: name}
+				builder = builder.WithObjects(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a"}})
+			}
+			var applied *corev1.ConfigMap
+			bs := &BatchScheduler{client: builder.WithInterceptorFuncs(interceptor.Funcs{
+				Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+					if patch != client.Apply {
+						return c.Patch(ctx, obj, patch, opts...)
+					}
+					if tt.applyErr != nil {
+						return tt.applyErr
+					}
+					applied = obj.(*corev1.ConfigMap)
+					return nil
+				},
+			}).Build()}
+
+			err := bs.savePlan(context.Background(), injector, tt.plan)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+
+			if tt.plan != nil {
+				if got := len(tt.plan.Changes); got != tt.inStatus {
+					t.Errorf("%d changes in the status, want %d", got, tt.inStatus)
+				}
+				if got := tt.plan.ConfigMapName != ""; got != tt.configMap {
+					t.Errorf("ConfigMap referenced = %v, want %v", got, tt.configMap)
+				}
+			}
+			if tt.configMap {
+				if applied == nil || applied.Name != name || applied.Data[planConfigMapKey] == "" {
+					t.Errorf("plan ConfigMap not written: %+v", applied)
+				} else if !metav1.IsControlledBy(applied, injector) {
+					t.Error("plan ConfigMap is not owned by the injector")
+				}
+			}
+			if tt.previous {
+				err := bs.client.Get(context.Background(), client.ObjectKey{Namespace: "team-a", Name: name}, &corev1.ConfigMap{})
+				if kept := err == nil; kept != tt.keepsOther {
+					t.Errorf("previous ConfigMap kept = %v, want %v (%v)", kept, tt.keepsOther, err)
+				}
+				if err != nil && !apierrors.IsNotFound(err) {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -2,12 +2,15 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strconv"
 	"strings"
-	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
@@ -17,62 +20,333 @@ import (
 func (bs *BatchScheduler) processJob(ctx context.Context, job ReconcileJob) error {
 	log := log.FromContext(ctx)
 
-	interval := defaultReconcileInterval
-	if customInterval, ok := job.Injector.Annotations[annotationReconcileInterval]; ok {
-		if parsed, err := time.ParseDuration(customInterval); err == nil {
-			interval = parsed
-		}
+	if err := bs.markProgressing(ctx, job.Injector); err != nil {
+		return fmt.Errorf("unable to update status: %w", err)
+	}
+	bs.recorder.Eventf(job.Injector, corev1.EventTypeNormal, eventReasonRunStarted,
+		"Injecting metadata into the resources of %d selectors", len(job.Injector.GetSpec().Selectors))
+
+	var result JobResult
+	interval, err := bs.reconcileInterval(job.Injector)
+	if err != nil {
+		result.IntervalError = err.Error()
 	}
+	result.Deprecations = deprecatedAnnotations(job.Injector)
 
 	intervalStatus := interval.String()
-	if disabled, _ := strconv.ParseBool(job.Injector.Annotations[annotationDisableAutoReconcile]); disabled {
+	schedule, err := parseSchedule(job.Injector.GetSpec())
+	switch {
+	case err != nil:
+		result.ScheduleError = err.Error()
+	case schedule != nil:
+		intervalStatus = formatSchedule(job.Injector.GetSpec())
+	}
+	suspended, err := isSuspended(job.Injector)
+	if err != nil {
+		result.SuspendError = err.Error()
+	}
+	if suspended {
 		intervalStatus = "False"
 	}
 
-	for _, selector := range job.Injector.Spec.Selectors {
-		log.Info("Processing selector", "selector", selector)
+	if job.Injector.GetSpec().DryRun {
+		result.DryRun = true
+		result.Plan = &corev1alpha1.DryRunPlan{}
+	}
 
-		resource := strings.ToLower(fmt.Sprintf("%ss", selector.Kind))
-		gvr := getGroupVersionResource(selector.Group, selector.Version, resource)
-		namespaces := getNamespaces(selector.Namespaces)
+	for i, selector := range job.Injector.GetSpec().Selectors {
+		log.Info("Processing selector", "selector", selector)
 
-		for _, ns := range namespaces {
-			if err := bs.processNamespace(ctx, job, selector, gvr, ns); err != nil {
-				log.Error(err, "failed to process namespace", "namespace", ns)
-				continue
-			}
+		selectorStatus := corev1alpha1.SelectorStatus{Index: int32(i), Kind: selector.Kind}
+		if denied := bs.processSelector(ctx, job, selector, &selectorStatus, result.Plan); denied != "" {
+			result.Unauthorized = append(result.Unauthorized, fmt.Sprintf("selector %d: %s", i, denied))
+		}
+		if selectorStatus.Resource == "" {
+			result.UnresolvedSelectors = append(result.UnresolvedSelectors, selectorStatus.LastError)
 		}
+		result.Selectors = append(result.Selectors, selectorStatus)
 	}
 
-	return bs.updateStatus(ctx, job.Injector, intervalStatus)
+	totals := result.totals()
+	log.Info("Processed injector", "matched", totals.Matched, "updated", totals.Updated, "inSync", totals.InSync, "failed", totals.Failed, "dryRun", result.DryRun)
+	if err := bs.savePlan(ctx, job.Injector, result.Plan); err != nil {
+		log.Error(err, "failed to store the dry-run plan")
+	}
+	bs.recordRunEvents(job.Injector, result)
+
+	return bs.updateStatus(ctx, job.Injector, job.NextRun, intervalStatus, result)
 }
 
-func (bs *BatchScheduler) processNamespace(ctx context.Context, job ReconcileJob, selector corev1alpha1.ResourceSelector, gvr schema.GroupVersionResource, namespace string) error {
-	list, err := bs.dynamicClient.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{})
+// processSelector processes the resources selected by a single selector and
+// returns why part of the selection was denied, if it was. During a dry run,
+// plan collects the changes instead of writing them.
+func (bs *BatchScheduler) processSelector(ctx context.Context, job ReconcileJob, selector corev1alpha1.ResourceSelector, status *corev1alpha1.SelectorStatus, plan *corev1alpha1.DryRunPlan) string {
+	log := log.FromContext(ctx)
+
+	mapping, err := bs.resolveMapping(selector)
 	if err != nil {
-		return fmt.Errorf("unable to list resources: %w", err)
+		log.Error(err, "failed to resolve resource", "selector", selector)
+		status.LastError = err.Error()
+		return ""
 	}
+	status.Resource = formatResource(mapping.Resource)
 
-	for _, item := range list.Items {
-		if !shouldProcessResource(item.GetName(), selector.Names) {
+	if clusterScopeDenied(job.Injector, mapping) {
+		return fmt.Sprintf("%s is cluster-scoped and can only be selected by a ClusterMetadataInjector", status.Resource)
+	}
+
+	var denial string
+	namespaces := []string{""}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		var denied []string
+		namespaces, denied, err = bs.getNamespaces(ctx, job.Injector, selector)
+		if err != nil {
+			log.Error(err, "failed to resolve namespaces", "selector", selector)
+			status.LastError = err.Error()
+			return ""
+		}
+		if len(denied) > 0 {
+			log.Info("Skipping namespaces outside of the injector's namespace", "namespaces", denied)
+			denial = fmt.Sprintf("namespaces %s are outside of namespace %s", strings.Join(denied, ", "), job.Injector.GetNamespace())
+		}
+	}
+
+	for _, ns := range namespaces {
+		if err := bs.processNamespace(ctx, job, selector, mapping.Resource, ns, status, plan); err != nil {
+			log.Error(err, "failed to process namespace", "namespace", ns)
+			status.LastError = err.Error()
 			continue
 		}
+	}
+	return denial
+}
 
-		updateMetadata(&item, job.Injector.Spec.Inject.Labels, job.Injector.Spec.Inject.Annotations)
+func (bs *BatchScheduler) processNamespace(ctx context.Context, job ReconcileJob, selector corev1alpha1.ResourceSelector, gvr schema.GroupVersionResource, namespace string, status *corev1alpha1.SelectorStatus, plan *corev1alpha1.DryRunPlan) error {
+	items, err := bs.listTargets(ctx, job.Injector, selector, gvr, namespace)
+	if err != nil {
+		return err
+	}
+	status.Matched += int32(len(items))
 
-		_, err := bs.dynamicClient.Resource(gvr).Namespace(item.GetNamespace()).Update(
-			ctx,
-			&item,
-			metav1.UpdateOptions{},
-		)
+	for _, item := range items {
+		var changed bool
+		var skipped []string
+		if plan != nil {
+			// Dry runs are left out of the metrics, which count actual writes
+			changed, skipped, err = planItem(ctx, job.Injector, gvr, &item, plan)
+		} else {
+			changed, skipped, err = bs.processItem(ctx, job, gvr, &item)
+			observeItem(injectorKey(job.Injector), gvr, changed, err)
+		}
+		var conflict *conflictError
+		if errors.As(err, &conflict) {
+			log.FromContext(ctx).Info("Leaving resource with conflicting keys untouched",
+				"name", item.GetName(),
+				"namespace", item.GetNamespace(),
+				"keys", conflict.keys,
+			)
+			status.Conflicts++
+			status.LastConflict = fmt.Sprintf("%s/%s: %v", item.GetNamespace(), item.GetName(), err)
+			continue
+		}
 		if err != nil {
-			log.FromContext(ctx).Error(err, "failed to update resource",
+			log.FromContext(ctx).Error(err, "failed to process resource",
 				"name", item.GetName(),
 				"namespace", item.GetNamespace(),
 			)
+			status.Failed++
+			status.LastError = fmt.Sprintf("%s/%s: %v", item.GetNamespace(), item.GetName(), err)
 			continue
 		}
+
+		if changed {
+			status.Updated++
+		} else {
+			status.InSync++
+		}
+		// Resources with preserved keys also count as updated or in sync
+		if len(skipped) > 0 {
+			status.Skipped++
+			status.LastSkipped = fmt.Sprintf("%s/%s: %s", item.GetNamespace(), item.GetName(), strings.Join(skipped, ", "))
+		}
 	}
 
 	return nil
 }
+
+// processItem brings a single resource to the desired metadata and reports
+// whether it had to be changed, and which desired keys were skipped
+func (bs *BatchScheduler) processItem(ctx context.Context, job ReconcileJob, gvr schema.GroupVersionResource, item *unstructured.Unstructured) (bool, []string, error) {
+	var skipped []string
+	original, changed, err := bs.updateTarget(ctx, job.Injector, gvr, item, func(item *unstructured.Unstructured) error {
+		var err error
+		skipped, err = applyInjector(ctx, job.Injector, item)
+		return err
+	})
+	var conflict *conflictError
+	switch {
+	case errors.As(err, &conflict):
+		return false, nil, err
+	case err != nil:
+		return false, nil, fmt.Errorf("unable to patch resource: %w", err)
+	case !changed:
+		return false, skipped, nil
+	}
+	bs.recordTargetEvent(job.Injector, gvr, original, item)
+	return true, skipped, nil
+}
+
+// applyInjector sets the injector's metadata on the item in memory and
+// records the keys it owns. It returns the desired keys it had to skip.
+func applyInjector(ctx context.Context, injector corev1alpha1.Injector, item *unstructured.Unstructured) ([]string, error) {
+	log := log.FromContext(ctx).WithValues("name", item.GetName(), "namespace", item.GetNamespace())
+
+	owner := injectorKey(injector)
+	spec := injector.GetSpec()
+	labels := spec.Inject.Labels
+	annotations := spec.Inject.Annotations
+
+	record, err := getManagedKeys(item)
+	if err != nil {
+		return nil, err
+	}
+
+	desiredLabels, desiredAnnotations := labels, annotations
+	var skipped []string
+	switch spec.ConflictPolicy {
+	case corev1alpha1.ConflictPolicyOverwrite, "":
+		if conflicts := findConflicts(item, record, owner, labels, annotations); len(conflicts) > 0 {
+			log.Info("Overwriting keys managed by another injector", "keys", conflicts)
+		}
+	case corev1alpha1.ConflictPolicyIfNotPresent:
+		desiredLabels, desiredAnnotations, skipped = withoutPresentKeys(item, record[owner], labels, annotations)
+		if len(skipped) > 0 {
+			log.Info("Preserving keys already set on the resource", "keys", skipped)
+		}
+	case corev1alpha1.ConflictPolicyFail:
+		if _, _, conflicts := withoutPresentKeys(item, record[owner], labels, annotations); len(conflicts) > 0 {
+			return nil, &conflictError{keys: conflicts}
+		}
+	}
+
+	if spec.Prune {
+		pruneKeys(item, record, owner, labels, annotations)
+	}
+	addedLabels, addedAnnotations := addedKeys(item, record[owner], desiredLabels, desiredAnnotations)
+	updateMetadata(item, desiredLabels, desiredAnnotations)
+	claimKeys(record, owner, addedLabels, addedAnnotations)
+	return skipped, setManagedKeys(item, record)
+}
+
+func (bs *BatchScheduler) cleanupJob(ctx context.Context, injector corev1alpha1.Injector) error {
+	log := log.FromContext(ctx)
+
+	var errs []error
+	for _, selector := range injector.GetSpec().Selectors {
+		mapping, err := bs.resolveMapping(selector)
+		if err != nil {
+			// Nothing can be left behind on a kind the cluster no longer serves
+			log.Info("Skipping cleanup of unresolvable selector", "selector", selector, "reason", err.Error())
+			continue
+		}
+		if clusterScopeDenied(injector, mapping) {
+			continue
+		}
+
+		namespaces := []string{""}
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			namespaces, _, err = bs.getNamespaces(ctx, injector, selector)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+
+		for _, ns := range namespaces {
+			if err := bs.cleanupNamespace(ctx, injector, selector, mapping.Resource, ns); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (bs *BatchScheduler) cleanupNamespace(ctx context.Context, injector corev1alpha1.Injector, selector corev1alpha1.ResourceSelector, gvr schema.GroupVersionResource, namespace string) error {
+	items, err := bs.listTargets(ctx, injector, selector, gvr, namespace)
+	if err != nil {
+		if cleanupDenied(err) {
+			bs.skipCleanup(ctx, injector, err)
+			return nil
+		}
+		return err
+	}
+
+	owner := injectorKey(injector)
+
+	var errs []error
+	for _, item := range items {
+		_, _, err := bs.updateTarget(ctx, injector, gvr, &item, func(item *unstructured.Unstructured) error {
+			_, err := releaseKeys(item, owner)
+			return err
+		})
+		if err != nil {
+			if cleanupDenied(err) {
+				bs.skipCleanup(ctx, injector, fmt.Errorf("%s/%s: %w", item.GetNamespace(), item.GetName(), err))
+				continue
+			}
+			errs = append(errs, fmt.Errorf("unable to clean up %s/%s: %w", item.GetNamespace(), item.GetName(), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// cleanupDenied reports whether a cleanup error means the injector can no
+// longer reach the targets, as when its namespace is deleted along with the
+// impersonated service account or its RoleBinding. Retrying would then keep
+// the injector, and the namespace with it, in Terminating forever.
+func cleanupDenied(err error) bool {
+	return apierrors.IsForbidden(err) || apierrors.IsNotFound(err)
+}
+
+// skipCleanup reports the targets the injector could not reach during cleanup,
+// which are treated as having nothing left to release
+func (bs *BatchScheduler) skipCleanup(ctx context.Context, injector corev1alpha1.Injector, err error) {
+	log.FromContext(ctx).Info("Skipping cleanup of unreachable resources", "reason", err.Error())
+	bs.recorder.Eventf(injector, corev1.EventTypeWarning, eventReasonCleanupSkipped,
+		"Left the injected metadata of unreachable resources in place: %v", err)
+}
+
+func (bs *BatchScheduler) listTargets(ctx context.Context, injector corev1alpha1.Injector, selector corev1alpha1.ResourceSelector, gvr schema.GroupVersionResource, namespace string) ([]unstructured.Unstructured, error) {
+	listOptions := metav1.ListOptions{}
+	if selector.LabelSelector != nil {
+		labelSelector, err := metav1.LabelSelectorAsSelector(selector.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector: %w", err)
+		}
+		listOptions.LabelSelector = labelSelector.String()
+	}
+
+	dc, err := bs.clientFor(injector)
+	if err != nil {
+		return nil, err
+	}
+	list, err := dc.Resource(gvr).Namespace(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list resources: %w", err)
+	}
+
+	items := make([]unstructured.Unstructured, 0, len(list.Items))
+	for _, item := range list.Items {
+		if !shouldProcessResource(item.GetName(), selector.Names) {
+			continue
+		}
+		if isNamespaceExcluded(item.GetNamespace(), selector.ExcludeNamespaces) {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
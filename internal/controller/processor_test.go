@@ -0,0 +1,281 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+const (
+	testOwner = "team-a/injector"
+	testOther = "team-a/other"
+)
+
+// newItem builds a resource holding the given labels and managed keys record
+func newItem(t *testing.T, labels map[string]string, record map[string]ManagedKeys) *unstructured.Unstructured {
+	t.Helper()
+	item := &unstructured.Unstructured{}
+	item.SetAPIVersion("v1")
+	item.SetKind("ConfigMap")
+	item.SetNamespace("team-a")
+	item.SetName("target")
+	item.SetLabels(maps.Clone(labels))
+	if err := setManagedKeys(item, record); err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+func TestApplyInjector(t *testing.T) {
+	desired := map[string]string{"team": "platform", "tier": "backend"}
+
+	tests := []struct {
+		name     string
+		policy   corev1alpha1.ConflictPolicy
+		prune    bool
+		labels   map[string]string
+		record   map[string]ManagedKeys
+		want     map[string]string
+		owned    map[string]ManagedKeys
+		skipped  []string
+		conflict bool
+	}{
+		{
+			name:   "Overwrite replaces values set by others",
+			policy: corev1alpha1.ConflictPolicyOverwrite,
+			labels: map[string]string{"team": "payments"},
+			want:   desired,
+			owned:  map[string]ManagedKeys{testOwner: {Labels: []string{"team", "tier"}}},
+		},
+		{
+			name:   "unset policy overwrites",
+			labels: map[string]string{"team": "payments"},
+			want:   desired,
+			owned:  map[string]ManagedKeys{testOwner: {Labels: []string{"team", "tier"}}},
+		},
+		{
+			name:   "Overwrite takes over keys of another injector",
+			policy: corev1alpha1.ConflictPolicyOverwrite,
+			labels: map[string]string{"team": "payments"},
+			record: map[string]ManagedKeys{testOther: {Labels: []string{"team"}}},
+			want:   desired,
+			owned: map[string]ManagedKeys{
+				testOwner: {Labels: []string{"team", "tier"}},
+				testOther: {Labels: []string{"team"}},
+			},
+		},
+		{
+			name:   "keys already holding the desired value are not claimed",
+			policy: corev1alpha1.ConflictPolicyOverwrite,
+			labels: map[string]string{"team": "platform"},
+			want:   desired,
+			owned:  map[string]ManagedKeys{testOwner: {Labels: []string{"tier"}}},
+		},
+		{
+			name:    "IfNotPresent preserves values set by others",
+			policy:  corev1alpha1.ConflictPolicyIfNotPresent,
+			labels:  map[string]string{"team": "payments"},
+			want:    map[string]string{"team": "payments", "tier": "backend"},
+			owned:   map[string]ManagedKeys{testOwner: {Labels: []string{"tier"}}},
+			skipped: []string{"label:team"},
+		},
+		{
+			name:   "IfNotPresent updates keys the injector owns",
+			policy: corev1alpha1.ConflictPolicyIfNotPresent,
+			labels: map[string]string{"team": "core", "tier": "backend"},
+			record: map[string]ManagedKeys{testOwner: {Labels: []string{"team", "tier"}}},
+			want:   desired,
+			owned:  map[string]ManagedKeys{testOwner: {Labels: []string{"team", "tier"}}},
+		},
+		{
+			name:     "Fail leaves the resource untouched",
+			policy:   corev1alpha1.ConflictPolicyFail,
+			labels:   map[string]string{"team": "payments"},
+			want:     map[string]string{"team": "payments"},
+			conflict: true,
+		},
+		{
+			name:   "Fail applies missing keys",
+			policy: corev1alpha1.ConflictPolicyFail,
+			labels: map[string]string{"app": "api"},
+			want:   map[string]string{"app": "api", "team": "platform", "tier": "backend"},
+			owned:  map[string]ManagedKeys{testOwner: {Labels: []string{"team", "tier"}}},
+		},
+		{
+			name:   "prune removes keys no longer declared",
+			policy: corev1alpha1.ConflictPolicyOverwrite,
+			prune:  true,
+			labels: map[string]string{"team": "platform", "tier": "backend", "stale": "yes"},
+			record: map[string]ManagedKeys{testOwner: {Labels: []string{"stale", "team", "tier"}}},
+			want:   desired,
+			owned:  map[string]ManagedKeys{testOwner: {Labels: []string{"team", "tier"}}},
+		},
+		{
+			name:   "prune keeps keys another injector claims",
+			policy: corev1alpha1.ConflictPolicyOverwrite,
+			prune:  true,
+			labels: map[string]string{"team": "platform", "tier": "backend", "shared": "yes"},
+			record: map[string]ManagedKeys{
+				testOwner: {Labels: []string{"shared", "team", "tier"}},
+				testOther: {Labels: []string{"shared"}},
+			},
+			want: map[string]string{"team": "platform", "tier": "backend", "shared": "yes"},
+			owned: map[string]ManagedKeys{
+				testOwner: {Labels: []string{"team", "tier"}},
+				testOther: {Labels: []string{"shared"}},
+			},
+		},
+		{
+			name:   "without prune undeclared keys stay owned",
+			policy: corev1alpha1.ConflictPolicyOverwrite,
+			labels: map[string]string{"team": "platform", "tier": "backend", "stale": "yes"},
+			record: map[string]ManagedKeys{testOwner: {Labels: []string{"stale", "team", "tier"}}},
+			want:   map[string]string{"team": "platform", "tier": "backend", "stale": "yes"},
+			owned:  map[string]ManagedKeys{testOwner: {Labels: []string{"stale", "team", "tier"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injector := namespacedInjector("team-a").(*corev1alpha1.MetadataInjector)
+			injector.Spec = corev1alpha1.MetadataInjectorSpec{
+				Inject:         corev1alpha1.MetadataInjection{Labels: desired},
+				ConflictPolicy: tt.policy,
+				Prune:          tt.prune,
+			}
+			item := newItem(t, tt.labels, tt.record)
+			original := item.DeepCopy()
+
+			skipped, err := applyInjector(context.Background(), injector, item)
+			var conflict *conflictError
+			if tt.conflict {
+				if !errors.As(err, &conflict) {
+					t.Fatalf("expected a conflict, got %v", err)
+				}
+				if !reflect.DeepEqual(item, original) {
+					t.Errorf("resource changed despite the conflict: %v", item.Object)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !maps.Equal(item.GetLabels(), tt.want) {
+				t.Errorf("labels = %v, want %v", item.GetLabels(), tt.want)
+			}
+			if !slices.Equal(skipped, tt.skipped) {
+				t.Errorf("skipped = %q, want %q", skipped, tt.skipped)
+			}
+			record, err := getManagedKeys(item)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(record) != len(tt.owned) || (len(record) > 0 && !reflect.DeepEqual(record, tt.owned)) {
+				t.Errorf("managed keys = %v, want %v", record, tt.owned)
+			}
+		})
+	}
+}
+
+func TestReleaseKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		record   map[string]ManagedKeys
+		released bool
+		want     map[string]string
+		owned    map[string]ManagedKeys
+	}{
+		{
+			name:     "owned keys are removed",
+			labels:   map[string]string{"team": "platform", "app": "api"},
+			record:   map[string]ManagedKeys{testOwner: {Labels: []string{"team"}}},
+			released: true,
+			want:     map[string]string{"app": "api"},
+		},
+		{
+			name:   "keys another injector claims are kept",
+			labels: map[string]string{"team": "platform", "tier": "backend"},
+			record: map[string]ManagedKeys{
+				testOwner: {Labels: []string{"team", "tier"}},
+				testOther: {Labels: []string{"tier"}},
+			},
+			released: true,
+			want:     map[string]string{"tier": "backend"},
+			owned:    map[string]ManagedKeys{testOther: {Labels: []string{"tier"}}},
+		},
+		{
+			name:   "resources without a record for the injector are untouched",
+			labels: map[string]string{"team": "platform"},
+			record: map[string]ManagedKeys{testOther: {Labels: []string{"team"}}},
+			want:   map[string]string{"team": "platform"},
+			owned:  map[string]ManagedKeys{testOther: {Labels: []string{"team"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := newItem(t, tt.labels, tt.record)
+
+			released, err := releaseKeys(item, testOwner)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if released != tt.released {
+				t.Errorf("released = %v, want %v", released, tt.released)
+			}
+			if !maps.Equal(item.GetLabels(), tt.want) {
+				t.Errorf("labels = %v, want %v", item.GetLabels(), tt.want)
+			}
+			record, err := getManagedKeys(item)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(record) != len(tt.owned) || (len(record) > 0 && !reflect.DeepEqual(record, tt.owned)) {
+				t.Errorf("managed keys = %v, want %v", record, tt.owned)
+			}
+		})
+	}
+}
+
+func TestProcessNamespaceCounts(t *testing.T) {
+	configMap := func(name string, labels map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a", Labels: labels, ResourceVersion: "1"}}
+	}
+	bs := newTestWatcher(t,
+		configMap("new", nil),
+		configMap("synced", map[string]string{"team": "platform", "tier": "backend"}),
+		configMap("preserved-updated", map[string]string{"team": "payments"}),
+		configMap("preserved-synced", map[string]string{"team": "payments", "tier": "backend"}),
+	).scheduler
+
+	injector := watchingInjector()
+	injector.Spec.Watch = false
+	injector.Spec.ConflictPolicy = corev1alpha1.ConflictPolicyIfNotPresent
+	injector.Spec.Inject.Labels = map[string]string{"team": "platform", "tier": "backend"}
+
+	var status corev1alpha1.SelectorStatus
+	err := bs.processNamespace(context.Background(), ReconcileJob{Injector: injector}, injector.Spec.Selectors[0], configMapsResource, "team-a", &status, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Preserved keys are counted on top of the updated or in sync resources
+	if status.Matched != 4 || status.Updated != 2 || status.InSync != 2 || status.Skipped != 2 {
+		t.Errorf("matched %d, updated %d, in sync %d, skipped %d, want 4, 2, 2 and 2",
+			status.Matched, status.Updated, status.InSync, status.Skipped)
+	}
+	if status.LastSkipped == "" {
+		t.Error("last resource with preserved keys not reported")
+	}
+}
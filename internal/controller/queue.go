@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"container/heap"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newRunQueue() *runQueue {
+	return &runQueue{byKey: make(map[client.ObjectKey]*scheduledRun)}
+}
+
+func (q *runQueue) Len() int { return len(q.items) }
+
+func (q *runQueue) Less(i, j int) bool { return q.items[i].nextRun.Before(q.items[j].nextRun) }
+
+func (q *runQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *runQueue) Push(x any) {
+	item := x.(*scheduledRun)
+	item.index = len(q.items)
+	q.items = append(q.items, item)
+	q.byKey[item.key] = item
+}
+
+func (q *runQueue) Pop() any {
+	n := len(q.items)
+	item := q.items[n-1]
+	q.items[n-1] = nil
+	q.items = q.items[:n-1]
+	delete(q.byKey, item.key)
+	return item
+}
+
+// schedule sets the next run of the injector, replacing the one already queued
+func (q *runQueue) schedule(key client.ObjectKey, nextRun time.Time) {
+	if item, ok := q.byKey[key]; ok {
+		item.nextRun = nextRun
+		heap.Fix(q, item.index)
+		return
+	}
+	heap.Push(q, &scheduledRun{key: key, nextRun: nextRun})
+}
+
+func (q *runQueue) remove(key client.ObjectKey) {
+	if item, ok := q.byKey[key]; ok {
+		heap.Remove(q, item.index)
+	}
+}
+
+// popDue removes and returns the runs that are not after now, earliest first
+func (q *runQueue) popDue(now time.Time) []*scheduledRun {
+	var due []*scheduledRun
+	for q.Len() > 0 && !q.items[0].nextRun.After(now) {
+		due = append(due, heap.Pop(q).(*scheduledRun))
+	}
+	return due
+}
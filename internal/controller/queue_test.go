@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRunQueue(t *testing.T) {
+	now := time.Now()
+	a := client.ObjectKey{Namespace: "team-a", Name: "a"}
+	b := client.ObjectKey{Namespace: "team-b", Name: "b"}
+	c := client.ObjectKey{Name: "c"}
+
+	tests := []struct {
+		name string
+		ops  func(q *runQueue)
+		due  []client.ObjectKey
+		left int
+	}{
+		{
+			name: "empty queue has nothing due",
+			ops:  func(q *runQueue) {},
+		},
+		{
+			name: "due runs are popped earliest first",
+			ops: func(q *runQueue) {
+				q.schedule(a, now.Add(-time.Second))
+				q.schedule(b, now.Add(-time.Minute))
+				q.schedule(c, now)
+			},
+			due: []client.ObjectKey{b, a, c},
+		},
+		{
+			name: "future runs stay queued",
+			ops: func(q *runQueue) {
+				q.schedule(a, now.Add(time.Minute))
+				q.schedule(b, now.Add(-time.Minute))
+			},
+			due:  []client.ObjectKey{b},
+			left: 1,
+		},
+		{
+			name: "scheduling again replaces the queued run",
+			ops: func(q *runQueue) {
+				q.schedule(a, now.Add(-time.Minute))
+				q.schedule(b, now.Add(-time.Second))
+				q.schedule(a, now.Add(time.Minute))
+			},
+			due:  []client.ObjectKey{b},
+			left: 1,
+		},
+		{
+			name: "rescheduling earlier moves the run ahead",
+			ops: func(q *runQueue) {
+				q.schedule(a, now.Add(-time.Second))
+				q.schedule(b, now.Add(time.Minute))
+				q.schedule(b, now.Add(-time.Minute))
+			},
+			due: []client.ObjectKey{b, a},
+		},
+		{
+			name: "removed runs are not dispatched",
+			ops: func(q *runQueue) {
+				q.schedule(a, now.Add(-time.Minute))
+				q.schedule(b, now.Add(-time.Second))
+				q.schedule(c, now.Add(time.Minute))
+				q.remove(a)
+				q.remove(c)
+			},
+			due: []client.ObjectKey{b},
+		},
+		{
+			name: "removing an unknown key is a no-op",
+			ops: func(q *runQueue) {
+				q.schedule(a, now.Add(-time.Minute))
+				q.remove(b)
+			},
+			due: []client.ObjectKey{a},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newRunQueue()
+			tt.ops(q)
+
+			var due []client.ObjectKey
+			for _, run := range q.popDue(now) {
+				due = append(due, run.key)
+			}
+			if !slices.Equal(due, tt.due) {
+				t.Errorf("popDue() = %v, want %v", due, tt.due)
+			}
+			if q.Len() != tt.left || len(q.byKey) != tt.left {
+				t.Errorf("queue holds %d runs and %d keys, want %d", q.Len(), len(q.byKey), tt.left)
+			}
+		})
+	}
+}
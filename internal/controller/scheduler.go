@@ -2,82 +2,268 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/client-go/dynamic"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
 )
 
-func NewBatchScheduler(c client.Client, dc dynamic.Interface, batchInterval time.Duration, workers int) *BatchScheduler {
+func NewBatchScheduler(c client.Client, dc dynamic.Interface, mapper meta.RESTMapper, opts Options) *BatchScheduler {
+	opts = opts.withDefaults()
 	return &BatchScheduler{
-		client:        c,
-		dynamicClient: dc,
-		batchInterval: batchInterval,
-		jobsChan:      make(chan ReconcileJob, 100),
-		workers:       workers,
-		stopChan:      make(chan struct{}),
+		client:                   c,
+		dynamicClient:            dc,
+		restMapper:               mapper,
+		batchInterval:            opts.BatchInterval.Duration,
+		defaultReconcileInterval: opts.DefaultReconcileInterval.Duration,
+		crossNamespaceAllowlist:  opts.CrossNamespaceAllowlist,
+		jobsChan:                 make(chan ReconcileJob, 100),
+		queue:                    newRunQueue(),
+		inFlight:                 make(map[string]struct{}),
+		workers:                  opts.Workers,
+		targetEvents:             opts.TargetEvents,
+		namespace:                opts.Namespace,
 	}
 }
 
-func (bs *BatchScheduler) Start() {
+var (
+	_ manager.Runnable               = &BatchScheduler{}
+	_ manager.LeaderElectionRunnable = &BatchScheduler{}
+)
+
+// Start runs the dispatcher and the workers until ctx is cancelled. The jobs
+// being processed are allowed to complete, while the ones still waiting in
+// jobsChan are dropped: the next leader schedules them again from the status.
+func (bs *BatchScheduler) Start(ctx context.Context) error {
+	log := log.FromContext(ctx)
+	log.Info("Starting scheduler", "workers", bs.workers, "batchInterval", bs.batchInterval)
+
+	var wg sync.WaitGroup
 	for i := 0; i < bs.workers; i++ {
-		bs.wg.Add(1)
-		go bs.worker()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bs.worker(ctx)
+		}()
+	}
+
+	bs.processBatches(ctx)
+	wg.Wait()
+
+	log.Info("Stopped scheduler", "droppedJobs", bs.drain())
+	return nil
+}
+
+// NeedLeaderElection makes the scheduler run on the elected leader only, so
+// replicas never write to the same targets concurrently
+func (bs *BatchScheduler) NeedLeaderElection() bool {
+	return true
+}
+
+// drain empties jobsChan and returns the number of jobs dropped
+func (bs *BatchScheduler) drain() int {
+	dropped := 0
+	for {
+		select {
+		case <-bs.jobsChan:
+			queueDepth.Dec()
+			dropped++
+		default:
+			return dropped
+		}
 	}
+}
 
-	bs.wg.Add(1)
-	go bs.processBatches()
+// Schedule queues the next run of the injector, replacing any run already queued
+func (bs *BatchScheduler) Schedule(key client.ObjectKey, nextRun time.Time) {
+	bs.queueMu.Lock()
+	defer bs.queueMu.Unlock()
+	bs.queue.schedule(key, nextRun)
 }
 
-func (bs *BatchScheduler) Stop() {
-	close(bs.stopChan)
-	bs.wg.Wait()
+// Unschedule drops the queued run of the injector, if any
+func (bs *BatchScheduler) Unschedule(key client.ObjectKey) {
+	bs.queueMu.Lock()
+	defer bs.queueMu.Unlock()
+	bs.queue.remove(key)
 }
 
-func (bs *BatchScheduler) processBatches() {
-	defer bs.wg.Done()
+// processBatches dispatches the injectors whose next run is due, checking the
+// run queue every batch interval until ctx is cancelled
+func (bs *BatchScheduler) processBatches(ctx context.Context) {
 	ticker := time.NewTicker(bs.batchInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-bs.stopChan:
+		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			ctx := context.Background()
-			var injectors corev1alpha1.MetadataInjectorList
-			if err := bs.client.List(ctx, &injectors); err != nil {
+			bs.dispatchDue(ctx)
+		}
+	}
+}
+
+// dispatchDue sends the runs that are due to the workers. The queue depth
+// gauge counts them from the moment they leave the run queue until a worker
+// picks them up.
+func (bs *BatchScheduler) dispatchDue(ctx context.Context) {
+	bs.queueMu.Lock()
+	due := bs.queue.popDue(time.Now())
+	bs.queueMu.Unlock()
+	queueDepth.Add(float64(len(due)))
+
+	for i, run := range due {
+		key := run.key
+		injector, err := bs.getInjector(ctx, key)
+		if err != nil {
+			queueDepth.Dec()
+			if errors.IsNotFound(err) {
 				continue
 			}
+			log.FromContext(ctx).Error(err, "failed to get injector", "injector", key.String())
+			bs.Schedule(key, time.Now().Add(bs.batchInterval))
+			continue
+		}
 
-			for i := range injectors.Items {
-				injector := &injectors.Items[i]
-				if shouldProcess(injector) {
-					bs.jobsChan <- ReconcileJob{
-						Injector: injector.DeepCopy(),
-						NextRun:  calculateNextRun(injector),
-					}
-				}
-			}
+		// Suspended injectors are queued again by the reconciler once resumed
+		if !shouldProcess(injector) {
+			queueDepth.Dec()
+			continue
+		}
+		job := ReconcileJob{
+			Injector:    injector,
+			NextRun:     bs.calculateNextRun(injector),
+			ScheduledAt: run.nextRun,
+		}
+		select {
+		case <-ctx.Done():
+			queueDepth.Sub(float64(len(due) - i))
+			return
+		case bs.jobsChan <- job:
 		}
 	}
 }
 
-func (bs *BatchScheduler) worker() {
-	defer bs.wg.Done()
+// getInjector fetches the injector identified by key, a ClusterMetadataInjector
+// when the key has no namespace and a MetadataInjector otherwise
+func (bs *BatchScheduler) getInjector(ctx context.Context, key client.ObjectKey) (corev1alpha1.Injector, error) {
+	var injector corev1alpha1.Injector = &corev1alpha1.MetadataInjector{}
+	if key.Namespace == "" {
+		injector = &corev1alpha1.ClusterMetadataInjector{}
+	}
+	if err := bs.client.Get(ctx, key, injector); err != nil {
+		return nil, err
+	}
+	return injector, nil
+}
+
+// runJob processes the job and queues the injector's next run. It reports
+// false without doing anything when the injector is already being processed,
+// whether by a worker or by the reconciler.
+func (bs *BatchScheduler) runJob(ctx context.Context, job ReconcileJob) (bool, error) {
+	key := injectorKey(job.Injector)
+
+	bs.queueMu.Lock()
+	if _, ok := bs.inFlight[key]; ok {
+		bs.queueMu.Unlock()
+		return false, nil
+	}
+	bs.inFlight[key] = struct{}{}
+	bs.queueMu.Unlock()
+
+	defer func() {
+		bs.queueMu.Lock()
+		delete(bs.inFlight, key)
+		bs.queueMu.Unlock()
+	}()
+
+	observeJobStart(key, job.ScheduledAt)
+	start := time.Now()
+	err := bs.processJob(ctx, job)
+	jobDuration.WithLabelValues(key).Observe(time.Since(start).Seconds())
+	if shouldProcess(job.Injector) {
+		bs.Schedule(client.ObjectKeyFromObject(job.Injector), job.NextRun)
+	} else {
+		bs.Unschedule(client.ObjectKeyFromObject(job.Injector))
+	}
+	return true, err
+}
+
+// listInjectors returns every MetadataInjector and ClusterMetadataInjector,
+// ordered by key
+func (bs *BatchScheduler) listInjectors(ctx context.Context) ([]corev1alpha1.Injector, error) {
+	var namespaced corev1alpha1.MetadataInjectorList
+	if err := bs.client.List(ctx, &namespaced); err != nil {
+		return nil, fmt.Errorf("unable to list injectors: %w", err)
+	}
+	var clusterWide corev1alpha1.ClusterMetadataInjectorList
+	if err := bs.client.List(ctx, &clusterWide); err != nil {
+		return nil, fmt.Errorf("unable to list cluster injectors: %w", err)
+	}
+
+	injectors := make([]corev1alpha1.Injector, 0, len(namespaced.Items)+len(clusterWide.Items))
+	for i := range clusterWide.Items {
+		injectors = append(injectors, &clusterWide.Items[i])
+	}
+	for i := range namespaced.Items {
+		injectors = append(injectors, &namespaced.Items[i])
+	}
+	slices.SortFunc(injectors, func(a, b corev1alpha1.Injector) int {
+		return strings.Compare(injectorKey(a), injectorKey(b))
+	})
+	return injectors, nil
+}
+
+// refreshJob re-fetches the injector of a job that waited in the jobs channel,
+// so a spec changed meanwhile is never overwritten by the queued copy. It
+// reports false when the job must be dropped: the injector is gone, is no
+// longer processed, or has a new spec that the reconciler runs or schedules.
+func (bs *BatchScheduler) refreshJob(ctx context.Context, job ReconcileJob) (ReconcileJob, bool) {
+	key := client.ObjectKeyFromObject(job.Injector)
+	injector, err := bs.getInjector(ctx, key)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			log.FromContext(ctx).Error(err, "failed to get injector", "injector", key.String())
+			bs.Schedule(key, time.Now().Add(bs.batchInterval))
+		}
+		return job, false
+	}
+	if !shouldProcess(injector) || injector.GetGeneration() != job.Injector.GetGeneration() {
+		return job, false
+	}
+
+	job.Injector = injector
+	job.NextRun = bs.calculateNextRun(injector)
+	return job, true
+}
+
+func (bs *BatchScheduler) worker(ctx context.Context) {
 	for {
 		select {
-		case <-bs.stopChan:
+		case <-ctx.Done():
 			return
 		case job := <-bs.jobsChan:
-			ctx := context.Background()
-			if err := bs.processJob(ctx, job); err != nil {
+			queueDepth.Dec()
+			job, ok := bs.refreshJob(ctx, job)
+			if !ok {
+				continue
+			}
+			// A job that has started is completed even if the manager stops meanwhile
+			if _, err := bs.runJob(context.WithoutCancel(ctx), job); err != nil {
 				log.FromContext(ctx).Error(err, "failed to process job",
-					"name", job.Injector.Name,
-					"namespace", job.Injector.Namespace)
+					"name", job.Injector.GetName(),
+					"namespace", job.Injector.GetNamespace())
 			}
 		}
 	}
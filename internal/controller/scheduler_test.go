@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+func TestDispatchDueQueueDepth(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	active := watchingInjector()
+	suspended := watchingInjector()
+	suspended.Name = "suspended"
+	suspended.Spec.Suspend = true
+
+	bs := &BatchScheduler{
+		client:                   fake.NewClientBuilder().WithScheme(scheme).WithObjects(active, suspended).Build(),
+		defaultReconcileInterval: defaultReconcileInterval,
+		jobsChan:                 make(chan ReconcileJob, 10),
+		queue:                    newRunQueue(),
+	}
+	past := time.Now().Add(-time.Minute)
+	bs.Schedule(client.ObjectKeyFromObject(active), past)
+	bs.Schedule(client.ObjectKeyFromObject(suspended), past)
+	bs.Schedule(client.ObjectKey{Namespace: "team-a", Name: "deleted"}, past)
+	bs.Schedule(client.ObjectKey{Namespace: "team-a", Name: "later"}, time.Now().Add(time.Hour))
+
+	// Only the runs handed to the workers count, not the ones still scheduled
+	initial := testutil.ToFloat64(queueDepth)
+	bs.dispatchDue(context.Background())
+	if got := testutil.ToFloat64(queueDepth) - initial; got != 1 {
+		t.Errorf("queue depth grew by %v after dispatching, want 1", got)
+	}
+	if dropped := bs.drain(); dropped != 1 {
+		t.Errorf("drained %d jobs, want 1", dropped)
+	}
+	if got := testutil.ToFloat64(queueDepth) - initial; got != 0 {
+		t.Errorf("queue depth grew by %v after draining, want 0", got)
+	}
+}
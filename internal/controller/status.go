@@ -0,0 +1,263 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+func (r JobResult) invalidSpec() bool {
+	return r.IntervalError != "" || r.ScheduleError != "" || r.SuspendError != "" || len(r.UnresolvedSelectors) > 0
+}
+
+// unauthorized reports whether a selector reached beyond the injector's scope
+func (r JobResult) unauthorized() bool {
+	return len(r.Unauthorized) > 0
+}
+
+// degraded reports whether a resolved selector hit a failure; unresolved
+// selectors are reported as an invalid spec instead
+func (r JobResult) degraded() bool {
+	return r.lastError() != ""
+}
+
+// skipped reports whether desired keys were left unapplied on some resources
+func (r JobResult) skipped() bool {
+	return r.totals().Skipped > 0
+}
+
+func (r JobResult) lastSkipped() string {
+	var lastSkipped string
+	for _, selector := range r.Selectors {
+		if selector.LastSkipped != "" {
+			lastSkipped = selector.LastSkipped
+		}
+	}
+	return lastSkipped
+}
+
+// conflicted reports whether resources were left untouched under the Fail conflict policy
+func (r JobResult) conflicted() bool {
+	return r.totals().Conflicts > 0
+}
+
+func (r JobResult) lastConflict() string {
+	var lastConflict string
+	for _, selector := range r.Selectors {
+		if selector.LastConflict != "" {
+			lastConflict = selector.LastConflict
+		}
+	}
+	return lastConflict
+}
+
+func (r JobResult) lastError() string {
+	var lastError string
+	for _, selector := range r.Selectors {
+		if selector.Resource != "" && selector.LastError != "" {
+			lastError = selector.LastError
+		}
+	}
+	return lastError
+}
+
+// totals sums the counters of every selector
+func (r JobResult) totals() corev1alpha1.SelectorStatus {
+	var totals corev1alpha1.SelectorStatus
+	for _, selector := range r.Selectors {
+		totals.Matched += selector.Matched
+		totals.Updated += selector.Updated
+		totals.InSync += selector.InSync
+		totals.Failed += selector.Failed
+		totals.Conflicts += selector.Conflicts
+		totals.Skipped += selector.Skipped
+	}
+	return totals
+}
+
+// summary describes the counters of the run
+func (r JobResult) summary() string {
+	totals := r.totals()
+	summary := fmt.Sprintf("%d resources matched, %d updated, %d already in sync", totals.Matched, totals.Updated, totals.InSync)
+	if r.DryRun {
+		summary = fmt.Sprintf("dry run: %d resources matched, %d would be updated, %d already in sync", totals.Matched, totals.Updated, totals.InSync)
+	}
+	if totals.Skipped > 0 {
+		summary += fmt.Sprintf(", %d with skipped keys", totals.Skipped)
+	}
+	return summary
+}
+
+// markProgressing records the start of a run before any target is touched
+func (bs *BatchScheduler) markProgressing(ctx context.Context, injector corev1alpha1.Injector) error {
+	now := metav1.Now()
+
+	patch := client.MergeFrom(injector.DeepCopyObject().(client.Object))
+	status := injector.GetStatus()
+	status.LastScheduledTime = &now
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               conditionTypeProgressing,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonRunning,
+		Message:            "Injecting metadata into the selected resources",
+		ObservedGeneration: injector.GetGeneration(),
+	})
+
+	return bs.client.Status().Patch(ctx, injector, patch)
+}
+
+func (bs *BatchScheduler) updateStatus(ctx context.Context, injector corev1alpha1.Injector, nextRun time.Time, intervalStatus string, result JobResult) error {
+	now := metav1.Now()
+
+	patch := client.MergeFrom(injector.DeepCopyObject().(client.Object))
+	status := injector.GetStatus()
+	if !result.invalidSpec() && !result.unauthorized() && !result.degraded() && !result.conflicted() {
+		status.LastSuccessfulTime = &now
+	}
+	status.NextScheduledTime = &metav1.Time{Time: nextRun}
+	status.Interval = intervalStatus
+	totals := result.totals()
+	status.Matched = totals.Matched
+	status.Updated = totals.Updated
+	status.InSync = totals.InSync
+	status.Selectors = result.Selectors
+	status.Plan = result.Plan
+
+	for _, condition := range buildConditions(result) {
+		condition.ObservedGeneration = injector.GetGeneration()
+		meta.SetStatusCondition(&status.Conditions, condition)
+	}
+
+	return bs.client.Status().Patch(ctx, injector, patch)
+}
+
+func buildConditions(result JobResult) []metav1.Condition {
+	totals := result.totals()
+	summary := result.summary()
+
+	invalidSpec := metav1.Condition{
+		Type:    conditionTypeInvalidSpec,
+		Status:  metav1.ConditionFalse,
+		Reason:  reasonSpecValid,
+		Message: "The spec is valid",
+	}
+	if result.invalidSpec() {
+		var messages []string
+		invalidSpec.Status = metav1.ConditionTrue
+		invalidSpec.Reason = reasonUnknownKind
+		if result.IntervalError != "" {
+			invalidSpec.Reason = reasonInvalidInterval
+			messages = append(messages, result.IntervalError)
+		}
+		if result.ScheduleError != "" {
+			invalidSpec.Reason = reasonInvalidSchedule
+			messages = append(messages, result.ScheduleError)
+		}
+		if result.SuspendError != "" {
+			invalidSpec.Reason = reasonInvalidSuspend
+			messages = append(messages, result.SuspendError)
+		}
+		invalidSpec.Message = strings.Join(append(messages, result.UnresolvedSelectors...), "; ")
+	}
+
+	deprecated := metav1.Condition{
+		Type:    conditionTypeDeprecated,
+		Status:  metav1.ConditionFalse,
+		Reason:  reasonUpToDate,
+		Message: "No deprecated settings are in use",
+	}
+	if len(result.Deprecations) > 0 {
+		deprecated.Status = metav1.ConditionTrue
+		deprecated.Reason = reasonDeprecatedAnnotations
+		deprecated.Message = strings.Join(result.Deprecations, "; ")
+	}
+
+	unauthorized := metav1.Condition{
+		Type:    conditionTypeUnauthorized,
+		Status:  metav1.ConditionFalse,
+		Reason:  reasonAuthorized,
+		Message: "All selected resources are within the injector's scope",
+	}
+	if result.unauthorized() {
+		unauthorized.Status = metav1.ConditionTrue
+		unauthorized.Reason = reasonOutOfScope
+		unauthorized.Message = strings.Join(result.Unauthorized, "; ")
+	}
+
+	degraded := metav1.Condition{
+		Type:    conditionTypeDegraded,
+		Status:  metav1.ConditionFalse,
+		Reason:  reasonSynced,
+		Message: summary,
+	}
+	if result.degraded() {
+		degraded.Status = metav1.ConditionTrue
+		degraded.Reason = reasonUpdateFailed
+		degraded.Message = fmt.Sprintf("%d resources failed, %s: %s", totals.Failed, summary, result.lastError())
+	}
+
+	conflict := metav1.Condition{
+		Type:    conditionTypeConflict,
+		Status:  metav1.ConditionFalse,
+		Reason:  reasonNoConflicts,
+		Message: "No resource was left untouched because of conflicting keys",
+	}
+	if result.conflicted() {
+		conflict.Status = metav1.ConditionTrue
+		conflict.Reason = reasonConflictingKeys
+		conflict.Message = fmt.Sprintf("%d resources already set keys to another value and were left untouched: %s", totals.Conflicts, result.lastConflict())
+	}
+
+	keysSkipped := metav1.Condition{
+		Type:    conditionTypeKeysSkipped,
+		Status:  metav1.ConditionFalse,
+		Reason:  reasonAllKeysApplied,
+		Message: "Every desired key was applied to the selected resources",
+	}
+	if result.skipped() {
+		keysSkipped.Status = metav1.ConditionTrue
+		keysSkipped.Reason = reasonPreservedValues
+		keysSkipped.Message = fmt.Sprintf("%d resources already set keys to another value, preserved under the IfNotPresent conflict policy: %s", totals.Skipped, result.lastSkipped())
+	}
+
+	ready := metav1.Condition{
+		Type:    conditionTypeReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  reasonSynced,
+		Message: summary,
+	}
+	switch {
+	case result.invalidSpec():
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = reasonInvalidSpec
+		ready.Message = invalidSpec.Message
+	case result.unauthorized():
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = reasonUnauthorized
+		ready.Message = unauthorized.Message
+	case result.degraded():
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = reasonUpdateFailed
+		ready.Message = degraded.Message
+	case result.conflicted():
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = reasonConflictingKeys
+		ready.Message = conflict.Message
+	}
+
+	progressing := metav1.Condition{
+		Type:    conditionTypeProgressing,
+		Status:  metav1.ConditionFalse,
+		Reason:  reasonCompleted,
+		Message: "The last run has completed",
+	}
+
+	return []metav1.Condition{ready, degraded, progressing, invalidSpec, unauthorized, deprecated, conflict, keysSkipped}
+}
@@ -1,10 +1,18 @@
 package controller
 
 import (
+	"context"
 	"sync"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
@@ -12,17 +20,102 @@ import (
 
 // ReconcileJob represents a scheduled reconciliation job
 type ReconcileJob struct {
-	Injector *corev1alpha1.MetadataInjector
+	Injector corev1alpha1.Injector
 	NextRun  time.Time
+	// ScheduledAt is when the run was due, zero for the runs triggered by the reconciler
+	ScheduledAt time.Time
 }
 
 // BatchScheduler handles batch processing of MetadataInjectors
 type BatchScheduler struct {
 	client        client.Client
 	dynamicClient dynamic.Interface
-	batchInterval time.Duration
-	jobsChan      chan ReconcileJob
-	workers       int
-	stopChan      chan struct{}
-	wg            sync.WaitGroup
+	// restConfig is the base of the clients impersonating the injectors' service accounts
+	restConfig           *rest.Config
+	clientsMu            sync.Mutex
+	impersonatingClients map[string]dynamic.Interface
+	restMapper           meta.RESTMapper
+	batchInterval        time.Duration
+	// defaultReconcileInterval is the interval of the injectors that set none
+	defaultReconcileInterval time.Duration
+	// crossNamespaceAllowlist lists the namespaces whose MetadataInjectors may target other namespaces
+	crossNamespaceAllowlist []string
+	jobsChan                chan ReconcileJob
+	// queueMu guards queue and inFlight
+	queueMu  sync.Mutex
+	queue    *runQueue
+	inFlight map[string]struct{}
+	workers  int
+	recorder record.EventRecorder
+	// targetEvents enables an event on every target whose metadata is changed
+	targetEvents bool
+	// namespace is where the dry-run plans of ClusterMetadataInjectors are stored
+	namespace string
+}
+
+// scheduledRun is an entry of the scheduler's run queue
+type scheduledRun struct {
+	key     client.ObjectKey
+	nextRun time.Time
+	index   int
+}
+
+// runQueue is a min-heap of scheduled runs ordered by next run time and
+// indexed by injector
+type runQueue struct {
+	items []*scheduledRun
+	byKey map[client.ObjectKey]*scheduledRun
+}
+
+// ResourceWatcher runs shared metadata informers for the resources selected by
+// watching injectors, reference-counted per GroupVersionResource
+type ResourceWatcher struct {
+	scheduler      *BatchScheduler
+	metadataClient metadata.Interface
+	// queue holds the watched resources waiting to be processed, so a slow
+	// patch never holds up the event delivery of an informer
+	queue workqueue.TypedRateLimitingInterface[watchItem]
+	mu    sync.Mutex
+	// ctx is the context the watcher was started with, nil until then
+	ctx           context.Context
+	informers     map[schema.GroupVersionResource]*watchedResource
+	registrations map[string]watchRegistration
+}
+
+type watchedResource struct {
+	informer cache.SharedIndexInformer
+	// stop stops the informer, nil until the watcher is started
+	stop context.CancelFunc
+	refs map[string]struct{}
+}
+
+// watchItem identifies a watched resource by its key in the informer's store
+type watchItem struct {
+	gvr schema.GroupVersionResource
+	key string
+}
+
+// watchRegistration holds the resolved resource of each selector of a watching injector
+type watchRegistration struct {
+	injector  corev1alpha1.Injector
+	resources []*schema.GroupVersionResource
+}
+
+// JobResult aggregates the outcome of a processed ReconcileJob
+type JobResult struct {
+	IntervalError       string
+	ScheduleError       string
+	SuspendError        string
+	Deprecations        []string
+	UnresolvedSelectors []string
+	Unauthorized        []string
+	Selectors           []corev1alpha1.SelectorStatus
+	DryRun              bool
+	Plan                *corev1alpha1.DryRunPlan
+}
+
+// ManagedKeys lists the label and annotation keys an injector owns on a resource
+type ManagedKeys struct {
+	Labels      []string `json:"labels,omitempty"`
+	Annotations []string `json:"annotations,omitempty"`
 }
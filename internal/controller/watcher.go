@@ -0,0 +1,293 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+func NewResourceWatcher(bs *BatchScheduler, mc metadata.Interface) *ResourceWatcher {
+	return &ResourceWatcher{
+		scheduler:      bs,
+		metadataClient: mc,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[watchItem](),
+			workqueue.TypedRateLimitingQueueConfig[watchItem]{Name: "metadata-injector-watch"},
+		),
+		informers:     make(map[schema.GroupVersionResource]*watchedResource),
+		registrations: make(map[string]watchRegistration),
+	}
+}
+
+var (
+	_ manager.Runnable               = &ResourceWatcher{}
+	_ manager.LeaderElectionRunnable = &ResourceWatcher{}
+)
+
+// Start runs the informers registered so far and the workers processing the
+// watched resources until ctx is cancelled, which also stops the informers
+func (rw *ResourceWatcher) Start(ctx context.Context) error {
+	log := log.FromContext(ctx)
+	log.Info("Starting watcher", "workers", rw.scheduler.workers)
+
+	rw.mu.Lock()
+	rw.ctx = ctx
+	for _, watched := range rw.informers {
+		rw.run(watched)
+	}
+	rw.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < rw.scheduler.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rw.processNextItem(ctx) {
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	rw.queue.ShutDown()
+	wg.Wait()
+	log.Info("Stopped watcher")
+	return nil
+}
+
+// NeedLeaderElection makes the watcher run on the elected leader only, like
+// the scheduler, since both write to the targets
+func (rw *ResourceWatcher) NeedLeaderElection() bool {
+	return true
+}
+
+// Register starts watching the resources selected by the injector, sharing
+// informers with the other injectors that select the same resources
+func (rw *ResourceWatcher) Register(ctx context.Context, injector corev1alpha1.Injector) {
+	selectors := injector.GetSpec().Selectors
+	registration := watchRegistration{
+		injector:  injector.DeepCopyObject().(corev1alpha1.Injector),
+		resources: make([]*schema.GroupVersionResource, len(selectors)),
+	}
+	for i, selector := range selectors {
+		mapping, err := rw.scheduler.resolveMapping(selector)
+		if err != nil || clusterScopeDenied(injector, mapping) {
+			// Unresolvable and denied selectors are reported by the scheduled runs
+			continue
+		}
+		registration.resources[i] = &mapping.Resource
+	}
+
+	key := injectorKey(injector)
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	previous := rw.registrations[key]
+	rw.registrations[key] = registration
+	for _, gvr := range registration.uniqueResources() {
+		rw.acquire(ctx, gvr, key)
+	}
+	for _, gvr := range previous.uniqueResources() {
+		if !slices.Contains(registration.uniqueResources(), gvr) {
+			rw.release(ctx, gvr, key)
+		}
+	}
+}
+
+// Unregister stops watching on behalf of the injector, tearing down the
+// informers no other injector needs anymore
+func (rw *ResourceWatcher) Unregister(ctx context.Context, key string) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	registration, ok := rw.registrations[key]
+	if !ok {
+		return
+	}
+	delete(rw.registrations, key)
+	for _, gvr := range registration.uniqueResources() {
+		rw.release(ctx, gvr, key)
+	}
+}
+
+// acquire must be called with the lock held
+func (rw *ResourceWatcher) acquire(ctx context.Context, gvr schema.GroupVersionResource, key string) {
+	watched, ok := rw.informers[gvr]
+	if !ok {
+		informer := metadatainformer.NewFilteredMetadataInformer(
+			rw.metadataClient, gvr, metav1.NamespaceAll, 0, cache.Indexers{}, nil,
+		).Informer()
+
+		handle := func(obj interface{}) {
+			rw.handle(gvr, obj)
+		}
+		if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    handle,
+			UpdateFunc: func(_, obj interface{}) { handle(obj) },
+		}); err != nil {
+			log.FromContext(ctx).Error(err, "failed to watch resource", "resource", formatResource(gvr))
+			return
+		}
+
+		watched = &watchedResource{
+			informer: informer,
+			refs:     make(map[string]struct{}),
+		}
+		rw.informers[gvr] = watched
+		if rw.ctx != nil {
+			rw.run(watched)
+		}
+		log.FromContext(ctx).Info("Started watching resource", "resource", formatResource(gvr))
+	}
+	watched.refs[key] = struct{}{}
+}
+
+// release must be called with the lock held
+func (rw *ResourceWatcher) release(ctx context.Context, gvr schema.GroupVersionResource, key string) {
+	watched, ok := rw.informers[gvr]
+	if !ok {
+		return
+	}
+	delete(watched.refs, key)
+	if len(watched.refs) > 0 {
+		return
+	}
+
+	if watched.stop != nil {
+		watched.stop()
+	}
+	delete(rw.informers, gvr)
+	log.FromContext(ctx).Info("Stopped watching resource", "resource", formatResource(gvr))
+}
+
+// run starts the informer until it is released or the watcher stops, and
+// must be called with the lock held once the watcher is started
+func (rw *ResourceWatcher) run(watched *watchedResource) {
+	ctx, cancel := context.WithCancel(rw.ctx)
+	watched.stop = cancel
+	go watched.informer.Run(ctx.Done())
+}
+
+// handle queues the resource an informer delivered, leaving the patching to
+// the workers
+func (rw *ResourceWatcher) handle(gvr schema.GroupVersionResource, obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	rw.queue.Add(watchItem{gvr: gvr, key: key})
+}
+
+// processNextItem processes a queued resource, retrying it with a backoff on
+// failure, and reports false once the queue is shut down
+func (rw *ResourceWatcher) processNextItem(ctx context.Context) bool {
+	item, shutdown := rw.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer rw.queue.Done(item)
+
+	if err := rw.process(ctx, item); err != nil {
+		if rw.queue.NumRequeues(item) < maxWatchRetries {
+			rw.queue.AddRateLimited(item)
+			return true
+		}
+		log.FromContext(ctx).Error(err, "giving up on watched resource", "resource", formatResource(item.gvr), "key", item.key)
+	}
+	rw.queue.Forget(item)
+	return true
+}
+
+// process applies the metadata of every watching injector that selects the
+// resource, as currently held by the informer
+func (rw *ResourceWatcher) process(ctx context.Context, item watchItem) error {
+	rw.mu.Lock()
+	watched, ok := rw.informers[item.gvr]
+	var registrations []watchRegistration
+	if ok {
+		for key := range watched.refs {
+			registrations = append(registrations, rw.registrations[key])
+		}
+	}
+	rw.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	obj, exists, err := watched.informer.GetStore().GetByKey(item.key)
+	if err != nil || !exists {
+		// A resource deleted meanwhile needs nothing anymore
+		return err
+	}
+	partial, ok := obj.(*metav1.PartialObjectMetadata)
+	if !ok {
+		return nil
+	}
+
+	var errs []error
+	for _, registration := range registrations {
+		for i, selector := range registration.injector.GetSpec().Selectors {
+			resource := registration.resources[i]
+			if resource == nil || *resource != item.gvr {
+				continue
+			}
+			if !rw.scheduler.matchesSelector(ctx, registration.injector, selector, partial) {
+				continue
+			}
+
+			// The informer's copy is shared, so the conversion works on its own copy
+			content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(partial)
+			if err != nil {
+				log.FromContext(ctx).Error(err, "failed to convert resource", "name", partial.Name, "namespace", partial.Namespace)
+				break
+			}
+			target := &unstructured.Unstructured{Object: content}
+
+			job := ReconcileJob{Injector: registration.injector}
+			changed, _, err := rw.scheduler.processItem(ctx, job, item.gvr, target)
+			// Conflicts are reported in the status by the scheduled runs
+			var conflict *conflictError
+			isConflict := errors.As(err, &conflict)
+			// Only attempted patches are counted, since resources such as Pods
+			// are updated far more often than their metadata needs injecting
+			if changed || (err != nil && !isConflict) {
+				observeItem(injectorKey(registration.injector), item.gvr, changed, err)
+			}
+			if err != nil && !isConflict {
+				log.FromContext(ctx).Error(err, "failed to process watched resource",
+					"name", partial.Name,
+					"namespace", partial.Namespace,
+					"injector", injectorKey(registration.injector),
+				)
+				errs = append(errs, err)
+			}
+			// A single pass applies the injector's metadata, whatever the number of matching selectors
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r watchRegistration) uniqueResources() []schema.GroupVersionResource {
+	var resources []schema.GroupVersionResource
+	for _, gvr := range r.resources {
+		if gvr != nil && !slices.Contains(resources, *gvr) {
+			resources = append(resources, *gvr)
+		}
+	}
+	return resources
+}
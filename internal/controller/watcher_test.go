@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	"k8s.io/client-go/tools/record"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+var configMapsResource = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// testRESTMapper maps the core kinds used by the tests
+func testRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+	return mapper
+}
+
+func newTestWatcher(t *testing.T, configMaps ...*corev1.ConfigMap) *ResourceWatcher {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	objects := make([]runtime.Object, 0, len(configMaps))
+	for _, configMap := range configMaps {
+		objects = append(objects, configMap)
+	}
+
+	bs := &BatchScheduler{
+		dynamicClient: dynamicfake.NewSimpleDynamicClient(scheme, objects...),
+		restMapper:    testRESTMapper(),
+		workers:       1,
+		recorder:      record.NewFakeRecorder(10),
+	}
+	return NewResourceWatcher(bs, metadatafake.NewSimpleMetadataClient(metadatafake.NewTestScheme()))
+}
+
+func watchingInjector() *corev1alpha1.MetadataInjector {
+	return &corev1alpha1.MetadataInjector{
+		ObjectMeta: metav1.ObjectMeta{Name: "injector", Namespace: "team-a"},
+		Spec: corev1alpha1.MetadataInjectorSpec{
+			Selectors: []corev1alpha1.ResourceSelector{{Kind: "ConfigMap"}},
+			Inject:    corev1alpha1.MetadataInjection{Labels: map[string]string{"team": "platform"}},
+			Watch:     true,
+		},
+	}
+}
+
+func TestResourceWatcherProcess(t *testing.T) {
+	configMap := func(namespace string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: namespace, ResourceVersion: "1"}}
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		stored    bool
+		want      map[string]string
+	}{
+		{name: "resource in the injector's namespace is patched", namespace: "team-a", stored: true, want: map[string]string{"team": "platform"}},
+		{name: "resource in another namespace is left alone", namespace: "team-b", stored: true},
+		{name: "resource deleted meanwhile is skipped", namespace: "team-a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			target := configMap(tt.namespace)
+			rw := newTestWatcher(t, target)
+			rw.Register(ctx, watchingInjector())
+
+			watched := rw.informers[configMapsResource]
+			if watched == nil {
+				t.Fatal("configmaps are not watched")
+			}
+			if tt.stored {
+				partial := &metav1.PartialObjectMetadata{ObjectMeta: target.ObjectMeta}
+				partial.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("ConfigMap"))
+				if err := watched.informer.GetStore().Add(partial); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := rw.process(ctx, watchItem{gvr: configMapsResource, key: tt.namespace + "/settings"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			current, err := rw.scheduler.dynamicClient.Resource(configMapsResource).Namespace(tt.namespace).Get(ctx, "settings", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := current.GetLabels(); len(got) != len(tt.want) || got["team"] != tt.want["team"] {
+				t.Errorf("labels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceWatcherLifecycle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rw := newTestWatcher(t)
+	// Informers registered before the watcher starts wait for it
+	rw.Register(ctx, watchingInjector())
+	watched := rw.informers[configMapsResource]
+	if watched.stop != nil {
+		t.Fatal("informer started before the watcher")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if err := rw.Start(ctx); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+
+	waitFor(t, "the informer to start", func() bool {
+		rw.mu.Lock()
+		defer rw.mu.Unlock()
+		return watched.stop != nil && watched.informer.HasSynced()
+	})
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher did not stop with its context")
+	}
+	waitFor(t, "the informer to stop", watched.informer.IsStopped)
+}
+
+func TestResourceWatcherUnregister(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rw := newTestWatcher(t)
+	go func() { _ = rw.Start(ctx) }()
+	waitFor(t, "the watcher to start", func() bool {
+		rw.mu.Lock()
+		defer rw.mu.Unlock()
+		return rw.ctx != nil
+	})
+
+	first := watchingInjector()
+	second := watchingInjector()
+	second.Name = "other"
+	rw.Register(ctx, first)
+	rw.Register(ctx, second)
+
+	rw.mu.Lock()
+	watched := rw.informers[configMapsResource]
+	rw.mu.Unlock()
+
+	// The informer is shared until the last injector watching it goes away
+	rw.Unregister(ctx, injectorKey(first))
+	if _, ok := rw.informers[configMapsResource]; !ok {
+		t.Fatal("informer released while still in use")
+	}
+	rw.Unregister(ctx, injectorKey(second))
+	if _, ok := rw.informers[configMapsResource]; ok {
+		t.Fatal("informer kept after the last injector was unregistered")
+	}
+	waitFor(t, "the informer to stop", watched.informer.IsStopped)
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+// log is for logging in this package.
+var clustermetadatainjectorlog = logf.Log.WithName("clustermetadatainjector-resource")
+
+// SetupClusterMetadataInjectorWebhookWithManager registers the webhook for ClusterMetadataInjector in the manager.
+func SetupClusterMetadataInjectorWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&corev1alpha1.ClusterMetadataInjector{}).
+		WithValidator(&ClusterMetadataInjectorCustomValidator{RESTMapper: mgr.GetRESTMapper()}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-core-k8s-ruso-dev-v1alpha1-clustermetadatainjector,mutating=false,failurePolicy=fail,sideEffects=None,groups=core.k8s.ruso.dev,resources=clustermetadatainjectors,verbs=create;update,versions=v1alpha1,name=vclustermetadatainjector-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// ClusterMetadataInjectorCustomValidator applies the MetadataInjector
+// validation to ClusterMetadataInjectors
+type ClusterMetadataInjectorCustomValidator struct {
+	// RESTMapper resolves the kinds targeted by the selectors
+	RESTMapper meta.RESTMapper
+}
+
+var _ webhook.CustomValidator = &ClusterMetadataInjectorCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type ClusterMetadataInjector.
+func (v *ClusterMetadataInjectorCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	injector, ok := obj.(*corev1alpha1.ClusterMetadataInjector)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterMetadataInjector object but got %T", obj)
+	}
+	clustermetadatainjectorlog.Info("Validation for ClusterMetadataInjector upon creation", "name", injector.GetName())
+
+	return validateInjector(v.RESTMapper, nil, injector, "ClusterMetadataInjector")
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type ClusterMetadataInjector.
+func (v *ClusterMetadataInjectorCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	injector, ok := newObj.(*corev1alpha1.ClusterMetadataInjector)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterMetadataInjector object for the newObj but got %T", newObj)
+	}
+	old, ok := oldObj.(*corev1alpha1.ClusterMetadataInjector)
+	if !ok {
+		return nil, fmt.Errorf("expected a ClusterMetadataInjector object for the oldObj but got %T", oldObj)
+	}
+	clustermetadatainjectorlog.Info("Validation for ClusterMetadataInjector upon update", "name", injector.GetName())
+
+	// Removing the finalizer of an injector being deleted must always be allowed
+	if !injector.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+
+	return validateInjector(v.RESTMapper, old, injector, "ClusterMetadataInjector")
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type ClusterMetadataInjector.
+func (v *ClusterMetadataInjectorCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
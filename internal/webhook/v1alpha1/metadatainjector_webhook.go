@@ -0,0 +1,319 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+// log is for logging in this package.
+var metadatainjectorlog = logf.Log.WithName("metadatainjector-resource")
+
+// kindPattern matches API kinds, which are CamelCase singular names (e.g. Deployment)
+var kindPattern = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+
+// SetupMetadataInjectorWebhookWithManager registers the webhook for MetadataInjector in the manager.
+func SetupMetadataInjectorWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&corev1alpha1.MetadataInjector{}).
+		WithValidator(&MetadataInjectorCustomValidator{RESTMapper: mgr.GetRESTMapper()}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-core-k8s-ruso-dev-v1alpha1-metadatainjector,mutating=false,failurePolicy=fail,sideEffects=None,groups=core.k8s.ruso.dev,resources=metadatainjectors,verbs=create;update,versions=v1alpha1,name=vmetadatainjector-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// MetadataInjectorCustomValidator rejects MetadataInjectors the controller
+// could only report as invalid after the fact, and warns about selectors
+// that match no resource served by the cluster
+type MetadataInjectorCustomValidator struct {
+	// RESTMapper resolves the kinds targeted by the selectors
+	RESTMapper meta.RESTMapper
+}
+
+var _ webhook.CustomValidator = &MetadataInjectorCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type MetadataInjector.
+func (v *MetadataInjectorCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	injector, ok := obj.(*corev1alpha1.MetadataInjector)
+	if !ok {
+		return nil, fmt.Errorf("expected a MetadataInjector object but got %T", obj)
+	}
+	metadatainjectorlog.Info("Validation for MetadataInjector upon creation", "name", injector.GetName())
+
+	return validateInjector(v.RESTMapper, nil, injector, "MetadataInjector")
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type MetadataInjector.
+func (v *MetadataInjectorCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	injector, ok := newObj.(*corev1alpha1.MetadataInjector)
+	if !ok {
+		return nil, fmt.Errorf("expected a MetadataInjector object for the newObj but got %T", newObj)
+	}
+	old, ok := oldObj.(*corev1alpha1.MetadataInjector)
+	if !ok {
+		return nil, fmt.Errorf("expected a MetadataInjector object for the oldObj but got %T", oldObj)
+	}
+	metadatainjectorlog.Info("Validation for MetadataInjector upon update", "name", injector.GetName())
+
+	// Removing the finalizer of an injector being deleted must always be allowed
+	if !injector.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+
+	return validateInjector(v.RESTMapper, old, injector, "MetadataInjector")
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type MetadataInjector.
+func (v *MetadataInjectorCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validateInjector holds the validation shared by MetadataInjector and ClusterMetadataInjector.
+// On update, old is the stored object: updates leaving the spec and annotations
+// alone, such as the controller adding its finalizer, are always allowed, and
+// annotations keep being tolerated as long as their value does not change.
+func validateInjector(mapper meta.RESTMapper, old, injector corev1alpha1.Injector, kind string) (admission.Warnings, error) {
+	if old != nil && equality.Semantic.DeepEqual(old.GetSpec(), injector.GetSpec()) &&
+		equality.Semantic.DeepEqual(old.GetAnnotations(), injector.GetAnnotations()) {
+		return nil, nil
+	}
+
+	var allErrs field.ErrorList
+	var warnings admission.Warnings
+
+	allErrs = append(allErrs, validateAnnotations(old, injector, field.NewPath("metadata", "annotations"))...)
+
+	spec := injector.GetSpec()
+	specPath := field.NewPath("spec")
+	for i, selector := range spec.Selectors {
+		selectorPath := specPath.Child("selectors").Index(i)
+		allErrs = append(allErrs, validateSelector(selector, selectorPath)...)
+
+		if warning := checkResource(mapper, selector, selectorPath); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+
+	allErrs = append(allErrs, validateSchedule(spec, specPath)...)
+	if spec.Interval != nil && spec.Interval.Duration < corev1alpha1.MinimumInterval {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("interval"), spec.Interval.Duration.String(),
+			fmt.Sprintf("must be at least %s", corev1alpha1.MinimumInterval)))
+	}
+	warnings = append(warnings, deprecationWarnings(injector)...)
+	allErrs = append(allErrs, validateServiceAccount(injector, specPath)...)
+
+	injectPath := specPath.Child("inject")
+	allErrs = append(allErrs, metav1validation.ValidateLabels(spec.Inject.Labels, injectPath.Child("labels"))...)
+	allErrs = append(allErrs, apivalidation.ValidateAnnotations(spec.Inject.Annotations, injectPath.Child("annotations"))...)
+
+	if len(allErrs) == 0 {
+		return warnings, nil
+	}
+	return warnings, apierrors.NewInvalid(
+		corev1alpha1.GroupVersion.WithKind(kind).GroupKind(),
+		injector.GetName(),
+		allErrs,
+	)
+}
+
+// deprecationWarnings asks users to move the scheduling annotations to the spec
+func deprecationWarnings(injector corev1alpha1.Injector) admission.Warnings {
+	var warnings admission.Warnings
+	annotations := injector.GetAnnotations()
+	if _, ok := annotations[corev1alpha1.AnnotationReconcileInterval]; ok {
+		warnings = append(warnings, fmt.Sprintf("metadata.annotations[%s]: deprecated, use spec.interval instead", corev1alpha1.AnnotationReconcileInterval))
+	}
+	if _, ok := annotations[corev1alpha1.AnnotationDisableAutoReconcile]; ok {
+		warnings = append(warnings, fmt.Sprintf("metadata.annotations[%s]: deprecated, use spec.suspend instead", corev1alpha1.AnnotationDisableAutoReconcile))
+	}
+	return warnings
+}
+
+// validateAnnotations checks the deprecated annotations that configure the
+// scheduled runs, leaving alone the ones whose value is unchanged from old
+func validateAnnotations(old, injector corev1alpha1.Injector, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	annotations := injector.GetAnnotations()
+	changed := func(key string) bool {
+		if old == nil {
+			return true
+		}
+		previous, ok := old.GetAnnotations()[key]
+		return !ok || previous != annotations[key]
+	}
+
+	if raw, ok := annotations[corev1alpha1.AnnotationReconcileInterval]; ok && changed(corev1alpha1.AnnotationReconcileInterval) {
+		path := fldPath.Key(corev1alpha1.AnnotationReconcileInterval)
+		interval, err := time.ParseDuration(raw)
+		switch {
+		case err != nil:
+			allErrs = append(allErrs, field.Invalid(path, raw, "must be a duration such as 30s, 5m or 1h"))
+		case interval < corev1alpha1.MinimumInterval:
+			allErrs = append(allErrs, field.Invalid(path, raw, fmt.Sprintf("must be at least %s", corev1alpha1.MinimumInterval)))
+		}
+	}
+
+	if raw, ok := annotations[corev1alpha1.AnnotationDisableAutoReconcile]; ok && changed(corev1alpha1.AnnotationDisableAutoReconcile) {
+		if _, err := strconv.ParseBool(raw); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(corev1alpha1.AnnotationDisableAutoReconcile), raw, "must be true or false"))
+		}
+	}
+
+	return allErrs
+}
+
+func validateSelector(selector corev1alpha1.ResourceSelector, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if !kindPattern.MatchString(selector.Kind) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("kind"), selector.Kind,
+			"must be a CamelCase kind such as Deployment, not a resource name such as deployments"))
+	}
+	if selector.Group != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(selector.Group) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("group"), selector.Group, msg))
+		}
+	}
+	if selector.Version != "" {
+		for _, msg := range validation.IsDNS1035Label(selector.Version) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("version"), selector.Version, msg))
+		}
+	}
+
+	allErrs = append(allErrs, validateNamespaces(selector.Namespaces, fldPath.Child("namespaces"))...)
+	allErrs = append(allErrs, validateNamespaces(selector.ExcludeNamespaces, fldPath.Child("excludeNamespaces"))...)
+
+	options := metav1validation.LabelSelectorValidationOptions{}
+	allErrs = append(allErrs, metav1validation.ValidateLabelSelector(selector.LabelSelector, options, fldPath.Child("labelSelector"))...)
+	allErrs = append(allErrs, metav1validation.ValidateLabelSelector(selector.NamespaceSelector, options, fldPath.Child("namespaceSelector"))...)
+
+	return allErrs
+}
+
+// validateSchedule checks the cron schedule and its time zone
+func validateSchedule(spec *corev1alpha1.MetadataInjectorSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	schedulePath := fldPath.Child("schedule")
+	switch {
+	case spec.Schedule == "":
+	case strings.Contains(spec.Schedule, "TZ"):
+		allErrs = append(allErrs, field.Invalid(schedulePath, spec.Schedule, "the time zone must be set with timeZone, not CRON_TZ or TZ"))
+	default:
+		schedule, err := cron.ParseStandard(spec.Schedule)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(schedulePath, spec.Schedule, err.Error()))
+			break
+		}
+		// Descriptors such as "@every 1s" can run more often than a cron
+		// expression, so the gap between two runs is checked as well
+		next := schedule.Next(time.Now())
+		if schedule.Next(next).Sub(next) < corev1alpha1.MinimumInterval {
+			allErrs = append(allErrs, field.Invalid(schedulePath, spec.Schedule,
+				fmt.Sprintf("must leave at least %s between runs", corev1alpha1.MinimumInterval)))
+		}
+	}
+	if spec.TimeZone != "" {
+		if _, err := time.LoadLocation(spec.TimeZone); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("timeZone"), spec.TimeZone, "must be an IANA time zone name"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateServiceAccount checks the service account impersonated by the injector
+func validateServiceAccount(injector corev1alpha1.Injector, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	spec := injector.GetSpec()
+
+	if spec.ServiceAccountName != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(spec.ServiceAccountName) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("serviceAccountName"), spec.ServiceAccountName, msg))
+		}
+	}
+
+	namespacePath := fldPath.Child("serviceAccountNamespace")
+	switch {
+	case injector.GetNamespace() != "":
+		if spec.ServiceAccountNamespace != "" && spec.ServiceAccountNamespace != injector.GetNamespace() {
+			allErrs = append(allErrs, field.Forbidden(namespacePath, "a MetadataInjector can only use a service account of its own namespace"))
+		}
+	case spec.ServiceAccountName != "" && spec.ServiceAccountNamespace == "":
+		allErrs = append(allErrs, field.Required(namespacePath, "required with serviceAccountName"))
+	case spec.ServiceAccountNamespace != "":
+		for _, msg := range validation.IsDNS1123Label(spec.ServiceAccountNamespace) {
+			allErrs = append(allErrs, field.Invalid(namespacePath, spec.ServiceAccountNamespace, msg))
+		}
+	}
+
+	return allErrs
+}
+
+func validateNamespaces(namespaces []string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, ns := range namespaces {
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), ns, msg))
+		}
+	}
+	return allErrs
+}
+
+// checkResource returns a warning when the selector matches no resource
+// served by the cluster. This is not an error: the CRD providing the kind
+// may simply not be installed yet.
+func checkResource(mapper meta.RESTMapper, selector corev1alpha1.ResourceSelector, fldPath *field.Path) string {
+	if mapper == nil || !kindPattern.MatchString(selector.Kind) {
+		return ""
+	}
+
+	gk := schema.GroupKind{Group: selector.Group, Kind: selector.Kind}
+	var versions []string
+	if selector.Version != "" {
+		versions = append(versions, selector.Version)
+	}
+
+	if _, err := mapper.RESTMapping(gk, versions...); err != nil {
+		if meta.IsNoMatchError(err) {
+			return fmt.Sprintf("%s: no API resource matches kind %q, the selector will not match anything until it is served", fldPath, gk.String())
+		}
+		return fmt.Sprintf("%s: unable to resolve kind %q: %v", fldPath, gk.String(), err)
+	}
+	return ""
+}
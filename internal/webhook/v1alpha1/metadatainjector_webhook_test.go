@@ -0,0 +1,267 @@
+package v1alpha1
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	corev1alpha1 "github.com/ruslanguns/metadata-injector-operator/api/v1alpha1"
+)
+
+func testRESTMapper() meta.RESTMapper {
+	apps := schema.GroupVersion{Group: "apps", Version: "v1"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{apps})
+	mapper.Add(apps.WithKind("Deployment"), meta.RESTScopeNamespace)
+	return mapper
+}
+
+func validInjector() *corev1alpha1.MetadataInjector {
+	return &corev1alpha1.MetadataInjector{
+		ObjectMeta: metav1.ObjectMeta{Name: "injector", Namespace: "team-a"},
+		Spec: corev1alpha1.MetadataInjectorSpec{
+			Selectors: []corev1alpha1.ResourceSelector{{Group: "apps", Kind: "Deployment"}},
+			Inject:    corev1alpha1.MetadataInjection{Labels: map[string]string{"team": "platform"}},
+		},
+	}
+}
+
+func TestValidateCreate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(injector *corev1alpha1.MetadataInjector)
+		wantErr string
+		warning string
+	}{
+		{
+			name:   "valid injector",
+			modify: func(*corev1alpha1.MetadataInjector) {},
+		},
+		{
+			name: "unparsable reconcile-interval annotation",
+			modify: func(injector *corev1alpha1.MetadataInjector) {
+				injector.Annotations = map[string]string{corev1alpha1.AnnotationReconcileInterval: "soon"}
+			},
+			wantErr: corev1alpha1.AnnotationReconcileInterval,
+			warning: "deprecated, use spec.interval",
+		},
+		{
+			name: "reconcile-interval annotation below the minimum",
+			modify: func(injector *corev1alpha1.MetadataInjector) {
+				injector.Annotations = map[string]string{corev1alpha1.AnnotationReconcileInterval: "1s"}
+			},
+			wantErr: "must be at least",
+			warning: "deprecated, use spec.interval",
+		},
+		{
+			name: "non boolean disable-auto-reconcile annotation",
+			modify: func(injector *corev1alpha1.MetadataInjector) {
+				injector.Annotations = map[string]string{corev1alpha1.AnnotationDisableAutoReconcile: "yes please"}
+			},
+			wantErr: corev1alpha1.AnnotationDisableAutoReconcile,
+			warning: "deprecated, use spec.suspend",
+		},
+		{
+			name: "label value over 63 characters",
+			modify: func(injector *corev1alpha1.MetadataInjector) {
+				injector.Spec.Inject.Labels["team"] = strings.Repeat("a", 64)
+			},
+			wantErr: "spec.inject.labels",
+		},
+		{
+			name: "invalid annotation key",
+			modify: func(injector *corev1alpha1.MetadataInjector) {
+				injector.Spec.Inject.Annotations = map[string]string{"not a key": "value"}
+			},
+			wantErr: "spec.inject.annotations",
+		},
+		{
+			name: "lowercase kind",
+			modify: func(injector *corev1alpha1.MetadataInjector) {
+				injector.Spec.Selectors[0].Kind = "deployments"
+			},
+			wantErr: "spec.selectors[0].kind",
+		},
+		{
+			name: "unknown kind is only a warning",
+			modify: func(injector *corev1alpha1.MetadataInjector) {
+				injector.Spec.Selectors[0].Kind = "Widget"
+			},
+			warning: `no API resource matches kind "Widget.apps"`,
+		},
+		{
+			name: "interval below the minimum",
+			modify: func(injector *corev1alpha1.MetadataInjector) {
+				injector.Spec.Interval = &metav1.Duration{Duration: time.Second}
+			},
+			wantErr: "spec.interval",
+		},
+		{
+			name: "invalid namespace",
+			modify: func(injector *corev1alpha1.MetadataInjector) {
+				injector.Spec.Selectors[0].Namespaces = []string{"Not_A_Namespace"}
+			},
+			wantErr: "spec.selectors[0].namespaces[0]",
+		},
+	}
+
+	validator := &MetadataInjectorCustomValidator{RESTMapper: testRESTMapper()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injector := validInjector()
+			tt.modify(injector)
+
+			warnings, err := validator.ValidateCreate(context.Background(), injector)
+			checkError(t, err, tt.wantErr)
+			if tt.warning == "" && len(warnings) > 0 {
+				t.Errorf("unexpected warnings: %q", warnings)
+			}
+			if tt.warning != "" && !strings.Contains(strings.Join(warnings, "\n"), tt.warning) {
+				t.Errorf("warnings = %q, want one containing %q", warnings, tt.warning)
+			}
+		})
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	invalidInterval := map[string]string{corev1alpha1.AnnotationReconcileInterval: "soon"}
+
+	tests := []struct {
+		name    string
+		old     func(injector *corev1alpha1.MetadataInjector)
+		modify  func(injector *corev1alpha1.MetadataInjector)
+		wantErr string
+	}{
+		{
+			name: "adding the finalizer to an invalid injector is allowed",
+			old: func(injector *corev1alpha1.MetadataInjector) {
+				injector.Spec.Selectors[0].Kind = "deployments"
+			},
+			modify: func(injector *corev1alpha1.MetadataInjector) {
+				injector.Spec.Selectors[0].Kind = "deployments"
+				injector.Finalizers = []string{"metadata-injector.ruso.dev/finalizer"}
+			},
+		},
+		{
+			name: "unchanged invalid annotation is tolerated",
+			old:  func(injector *corev1alpha1.MetadataInjector) { injector.Annotations = invalidInterval },
+			modify: func(injector *corev1alpha1.MetadataInjector) {
+				injector.Annotations = invalidInterval
+				injector.Spec.Inject.Labels["tier"] = "backend"
+			},
+		},
+		{
+			name: "changed annotation is validated",
+			old: func(injector *corev1alpha1.MetadataInjector) {
+				injector.Annotations = map[string]string{corev1alpha1.AnnotationReconcileInterval: "1m"}
+			},
+			modify:  func(injector *corev1alpha1.MetadataInjector) { injector.Annotations = invalidInterval },
+			wantErr: corev1alpha1.AnnotationReconcileInterval,
+		},
+		{
+			name:    "changed spec is validated",
+			old:     func(*corev1alpha1.MetadataInjector) {},
+			modify:  func(injector *corev1alpha1.MetadataInjector) { injector.Spec.Selectors[0].Kind = "deployments" },
+			wantErr: "spec.selectors[0].kind",
+		},
+		{
+			name: "removing the finalizer while deleting is allowed",
+			old: func(injector *corev1alpha1.MetadataInjector) {
+				injector.Finalizers = []string{"metadata-injector.ruso.dev/finalizer"}
+			},
+			modify: func(injector *corev1alpha1.MetadataInjector) {
+				now := metav1.Now()
+				injector.DeletionTimestamp = &now
+				injector.Spec.Selectors[0].Kind = "deployments"
+			},
+		},
+	}
+
+	validator := &MetadataInjectorCustomValidator{RESTMapper: testRESTMapper()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, injector := validInjector(), validInjector()
+			tt.old(old)
+			tt.modify(injector)
+
+			_, err := validator.ValidateUpdate(context.Background(), old, injector)
+			checkError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		timeZone string
+		wantErr  string
+	}{
+		{name: "no schedule"},
+		{name: "cron expression", schedule: "0 2 * * *", timeZone: "Europe/Madrid"},
+		{name: "descriptor", schedule: "@hourly"},
+		{name: "every descriptor above the minimum", schedule: "@every 30s"},
+		{name: "every descriptor below the minimum", schedule: "@every 1s", wantErr: "at least"},
+		{name: "unparsable expression", schedule: "61 * * * *", wantErr: "spec.schedule"},
+		{name: "time zone in the expression", schedule: "CRON_TZ=UTC 0 2 * * *", wantErr: "timeZone"},
+		{name: "unknown time zone", schedule: "0 2 * * *", timeZone: "Mars/Olympus_Mons", wantErr: "spec.timeZone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &corev1alpha1.MetadataInjectorSpec{Schedule: tt.schedule, TimeZone: tt.timeZone}
+			checkError(t, validateSchedule(spec, field.NewPath("spec")).ToAggregate(), tt.wantErr)
+		})
+	}
+}
+
+func TestValidateServiceAccount(t *testing.T) {
+	tests := []struct {
+		name      string
+		cluster   bool
+		account   string
+		namespace string
+		wantErr   string
+	}{
+		{name: "no service account"},
+		{name: "account of the injector's namespace", account: "injector"},
+		{name: "explicit own namespace", account: "injector", namespace: "team-a"},
+		{name: "account of another namespace", account: "injector", namespace: "team-b", wantErr: "own namespace"},
+		{name: "invalid account name", account: "Not_An_Account", wantErr: "spec.serviceAccountName"},
+		{name: "cluster injector with an account", cluster: true, account: "injector", namespace: "team-b"},
+		{name: "cluster injector without a namespace", cluster: true, account: "injector", wantErr: "spec.serviceAccountNamespace"},
+		{name: "cluster injector with an invalid namespace", cluster: true, account: "injector", namespace: "Team_B", wantErr: "spec.serviceAccountNamespace"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := corev1alpha1.MetadataInjectorSpec{ServiceAccountName: tt.account, ServiceAccountNamespace: tt.namespace}
+			var injector corev1alpha1.Injector = &corev1alpha1.MetadataInjector{
+				ObjectMeta: metav1.ObjectMeta{Name: "injector", Namespace: "team-a"},
+				Spec:       spec,
+			}
+			if tt.cluster {
+				injector = &corev1alpha1.ClusterMetadataInjector{ObjectMeta: metav1.ObjectMeta{Name: "injector"}, Spec: spec}
+			}
+			checkError(t, validateServiceAccount(injector, field.NewPath("spec")).ToAggregate(), tt.wantErr)
+		})
+	}
+}
+
+// checkError expects err to mention wantErr, or to be nil when wantErr is empty
+func checkError(t *testing.T, err error, wantErr string) {
+	t.Helper()
+	switch {
+	case wantErr == "" && err != nil:
+		t.Errorf("unexpected error: %v", err)
+	case wantErr != "" && err == nil:
+		t.Errorf("expected an error mentioning %q", wantErr)
+	case wantErr != "" && !strings.Contains(err.Error(), wantErr):
+		t.Errorf("error %q does not mention %q", err, wantErr)
+	}
+}